@@ -35,23 +35,31 @@ import (
 )
 
 type Configuration struct {
-	Certificate    Certificate        `yaml:"certificate"`
-	Storage        Storage            `yaml:"storage"`
-	License        License            `yaml:"license"`
-	LcpServer      ServerInfo         `yaml:"lcp"`
-	LsdServer      LsdServerInfo      `yaml:"lsd"`
-	FrontendServer FrontendServerInfo `yaml:"frontend"`
-	LsdNotifyAuth  Auth               `yaml:"lsd_notify_auth"`
-	LcpUpdateAuth  Auth               `yaml:"lcp_update_auth"`
-	CMSAccessAuth  Auth               `yaml:"cms_access_auth"`
-	LicenseStatus  LicenseStatus      `yaml:"license_status"`
-	Localization   Localization       `yaml:"localization"`
-	ComplianceMode bool               `yaml:"compliance_mode"`
-	GoofyMode      bool               `yaml:"goofy_mode"`
-	Profile        string             `yaml:"profile,omitempty"`
-
-	// DISABLED, see https://github.com/readium/readium-lcp-server/issues/109
-	//AES256_CBC_OR_GCM string             `yaml:"aes256_cbc_or_gcm,omitempty"`
+	Certificate     Certificate              `yaml:"certificate"`
+	Certificates    []Certificate            `yaml:"certificates,omitempty"`
+	RemoteSigning   RemoteSigning            `yaml:"remote_signing,omitempty"`
+	LicensePolicies map[string]LicensePolicy `yaml:"license_policies,omitempty"`
+	Tenants         []Tenant                 `yaml:"tenants,omitempty"`
+	Storage         Storage                  `yaml:"storage"`
+	StorageAudit    StorageAudit             `yaml:"storage_audit,omitempty"`
+	License         License                  `yaml:"license"`
+	LcpServer       ServerInfo               `yaml:"lcp"`
+	LsdServer       LsdServerInfo            `yaml:"lsd"`
+	FrontendServer  FrontendServerInfo       `yaml:"frontend"`
+	LsdNotifyAuth   Auth                     `yaml:"lsd_notify_auth"`
+	LcpUpdateAuth   Auth                     `yaml:"lcp_update_auth"`
+	CMSAccessAuth   Auth                     `yaml:"cms_access_auth"`
+	LicenseStatus   LicenseStatus            `yaml:"license_status"`
+	Localization    Localization             `yaml:"localization"`
+	ComplianceMode  bool                     `yaml:"compliance_mode"`
+	GoofyMode       bool                     `yaml:"goofy_mode"`
+	Profile         string                   `yaml:"profile,omitempty"`
+
+	ContentKeyEncryption ContentKeyEncryption `yaml:"content_key_encryption,omitempty"`
+
+	// default encryption mode of publication resources, CBC or GCM.
+	// CBC by default, as GCM is not supported by every reading system (see https://github.com/readium/readium-lcp-server/issues/109)
+	AES256_CBC_OR_GCM string `yaml:"aes256_cbc_or_gcm,omitempty"`
 }
 
 type ServerInfo struct {
@@ -62,6 +70,8 @@ type ServerInfo struct {
 	PublicBaseUrl string `yaml:"public_base_url,omitempty"`
 	Database      string `yaml:"database,omitempty"`
 	Directory     string `yaml:"directory,omitempty"`
+	// License Server only: deletion of a content with active licenses, "refuse" (default) or "revoke"
+	ContentDeletion string `yaml:"content_deletion,omitempty"`
 }
 
 type LsdServerInfo struct {
@@ -88,6 +98,63 @@ type Auth struct {
 type Certificate struct {
 	Cert       string `yaml:"cert"`
 	PrivateKey string `yaml:"private_key"`
+	ProviderCA string `yaml:"provider_ca,omitempty"`
+}
+
+// RemoteSigning defines a remote signing service holding the provider private key.
+// When a URL is set, licenses are signed by this service; Cert is the provider certificate.
+type RemoteSigning struct {
+	URL      string `yaml:"url"`
+	Cert     string `yaml:"cert"`
+	KeyID    string `yaml:"key_id,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Timeout  int    `yaml:"timeout,omitempty"` // in seconds
+	Retries  int    `yaml:"retries,omitempty"`
+}
+
+// LicensePolicy defines the rights of a license, selected by name at license generation.
+// Durations are expressed in days ("21d") or as Go durations ("48h"); the start offset is relative
+// to the issue date of the license, the duration and the potential rights duration to the start date.
+type LicensePolicy struct {
+	Print                   *int32 `yaml:"print,omitempty"`
+	Copy                    *int32 `yaml:"copy,omitempty"`
+	StartOffset             string `yaml:"start_offset,omitempty"`
+	Duration                string `yaml:"duration,omitempty"`
+	PotentialRightsDuration string `yaml:"potential_rights_duration,omitempty"`
+}
+
+// Tenant defines a publisher hosted by the License Server, with its own provider uri, signing certificates,
+// default license links, storage, license profile and credentials (an htpasswd file).
+// A tenant is selected by the identity of the caller or by a URL prefix; its contents and licenses
+// are not visible to other tenants.
+type Tenant struct {
+	ID           string            `yaml:"id"`
+	ProviderUri  string            `yaml:"provider_uri"`
+	PathPrefix   string            `yaml:"path_prefix,omitempty"`
+	AuthFile     string            `yaml:"auth_file"`
+	Certificate  Certificate       `yaml:"certificate"`
+	Certificates []Certificate     `yaml:"certificates,omitempty"`
+	Links        map[string]string `yaml:"links,omitempty"`
+	Storage      Storage           `yaml:"storage,omitempty"`
+	Profile      string            `yaml:"profile,omitempty"`
+}
+
+// ContentKeyEncryption defines the master key used for protecting content keys in the database.
+// The key is hex encoded; it is set directly or read from a file.
+// Previous master keys are kept during a key rotation, so that every content key remains readable.
+type ContentKeyEncryption struct {
+	MasterKey          string      `yaml:"master_key,omitempty"`
+	MasterKeyFile      string      `yaml:"master_key_file,omitempty"`
+	MasterKeyVersion   int         `yaml:"master_key_version,omitempty"`
+	PreviousMasterKeys []MasterKey `yaml:"previous_master_keys,omitempty"`
+}
+
+// MasterKey is a versioned master key
+type MasterKey struct {
+	Version int    `yaml:"version"`
+	Key     string `yaml:"key,omitempty"`
+	KeyFile string `yaml:"key_file,omitempty"`
 }
 
 type FileSystem struct {
@@ -95,8 +162,27 @@ type FileSystem struct {
 	URL       string `yaml:"url,omitempty"`
 }
 
+// WebDAV is the configuration of a WebDAV storage
+type WebDAV struct {
+	URL       string `yaml:"url"`
+	Username  string `yaml:"username,omitempty"`
+	Password  string `yaml:"password,omitempty"`
+	PublicURL string `yaml:"public_url,omitempty"`
+}
+
+// Mirror is the configuration of a storage mirrored to two backends
+type Mirror struct {
+	Primary   Storage `yaml:"primary"`
+	Secondary Storage `yaml:"secondary"`
+	// "sync" (default) or "async"
+	WritePolicy   string `yaml:"write_policy,omitempty"`
+	RetryInterval int    `yaml:"retry_interval,omitempty"` // in seconds
+}
+
 type Storage struct {
 	FileSystem FileSystem `yaml:"filesystem"`
+	WebDAV     WebDAV     `yaml:"webdav,omitempty"`
+	Mirror     *Mirror    `yaml:"mirror,omitempty"`
 	AccessId   string     `yaml:"access_id"`
 	DisableSSL bool       `yaml:"disable_ssl"`
 	PathStyle  bool       `yaml:"path_style"`
@@ -108,6 +194,18 @@ type Storage struct {
 	Token      string     `yaml:"token"`
 }
 
+// IsSet returns true if a storage is configured, in a given mode or as a file system
+func (s Storage) IsSet() bool {
+	return s.Mode != "" || s.FileSystem.Directory != ""
+}
+
+// StorageAudit is the configuration of the periodic audit of the storage by the License Server
+type StorageAudit struct {
+	Interval int `yaml:"interval"` // in hours, no audit if 0
+	// path of the json file in which the last report is written; the report is logged if empty
+	Report string `yaml:"report,omitempty"`
+}
+
 type License struct {
 	Links map[string]string `yaml:"links"`
 }
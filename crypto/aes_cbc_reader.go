@@ -0,0 +1,131 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// ErrInvalidCBCResource is returned when an encrypted resource is not a valid AES-CBC resource
+var ErrInvalidCBCResource = errors.New("invalid AES-CBC encrypted resource")
+
+// CBCReader gives random access to the plaintext of a resource encrypted by the CBC encrypter,
+// i.e. the IV followed by the ciphertext of the W3C (or PKCS#7) padded plaintext.
+// As each ciphertext block is the IV of the next one, only the blocks covering a byte range are decrypted.
+type CBCReader struct {
+	block  cipher.Block
+	r      io.ReaderAt
+	size   int64 // plaintext size
+	offset int64 // current offset, for Read and Seek
+}
+
+// NewCBCReader returns a reader over the plaintext of an AES-CBC encrypted resource of a given size.
+// The last block is decrypted at creation in order to compute the size of the plaintext.
+func NewCBCReader(key ContentKey, r io.ReaderAt, size int64) (*CBCReader, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// the IV and one block at least
+	if size < 2*aes.BlockSize || size%aes.BlockSize != 0 {
+		return nil, ErrInvalidCBCResource
+	}
+
+	last := make([]byte, 2*aes.BlockSize)
+	if err = readFullAt(r, last, size-2*aes.BlockSize); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, last[:aes.BlockSize]).CryptBlocks(last[aes.BlockSize:], last[aes.BlockSize:])
+
+	padding := int64(last[len(last)-1]) // padding length valid for both PKCS#7 and W3C schemes
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrInvalidCBCResource
+	}
+	return &CBCReader{block: block, r: r, size: size - aes.BlockSize - padding}, nil
+}
+
+// readFullAt reads len(buf) bytes at a given offset.
+// io.ReaderAt allows io.EOF along with a full buffer at the end of the input, which is not an error here.
+func readFullAt(r io.ReaderAt, buf []byte, off int64) error {
+
+	n, err := r.ReadAt(buf, off)
+	if err == io.EOF && n == len(buf) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the size of the plaintext
+func (c *CBCReader) Size() int64 {
+	return c.size
+}
+
+// ReadAt implements io.ReaderAt on the plaintext
+func (c *CBCReader) ReadAt(p []byte, off int64) (int, error) {
+
+	if off < 0 {
+		return 0, errors.New("crypto: negative offset")
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > c.size {
+		end = c.size
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	// read the blocks covering the range, preceded by the block used as IV
+	first := off / aes.BlockSize
+	last := (end - 1) / aes.BlockSize
+	buf := make([]byte, (last-first+2)*aes.BlockSize)
+	if err := readFullAt(c.r, buf, first*aes.BlockSize); err != nil {
+		return 0, err
+	}
+	cipher.NewCBCDecrypter(c.block, buf[:aes.BlockSize]).CryptBlocks(buf[aes.BlockSize:], buf[aes.BlockSize:])
+
+	start := aes.BlockSize + off - first*aes.BlockSize
+	n := copy(p, buf[start:start+end-off])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader on the plaintext
+func (c *CBCReader) Read(p []byte) (int, error) {
+
+	n, err := c.ReadAt(p, c.offset)
+	c.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker on the plaintext
+func (c *CBCReader) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("crypto: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("crypto: negative position")
+	}
+	c.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCBCReaderAt(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	cbc := NewAESCBCEncrypter()
+
+	for _, length := range []int{1, 15, 16, 17, 31, 32, 33, 100, 1000} {
+		plain := make([]byte, length)
+		rand.Read(plain)
+		var encrypted bytes.Buffer
+		if err := cbc.Encrypt(key, bytes.NewReader(plain), &encrypted); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := NewCBCReader(key, bytes.NewReader(encrypted.Bytes()), int64(encrypted.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reader.Size() != int64(length) {
+			t.Fatalf("Expected a size of %d, got %d", length, reader.Size())
+		}
+
+		// every range of the plaintext
+		for start := 0; start < length; start += 7 {
+			for end := start + 1; end <= length; end += 5 {
+				buf := make([]byte, end-start)
+				n, err := reader.ReadAt(buf, int64(start))
+				if err != nil || n != len(buf) {
+					t.Fatalf("ReadAt(%d, %d) on %d bytes: %d, %v", start, end, length, n, err)
+				}
+				if !bytes.Equal(buf, plain[start:end]) {
+					t.Fatalf("ReadAt(%d, %d) on %d bytes: unexpected plaintext", start, end, length)
+				}
+			}
+		}
+
+		// reading past the end
+		buf := make([]byte, 10)
+		n, err := reader.ReadAt(buf, int64(length-1))
+		if n != 1 || err != io.EOF || buf[0] != plain[length-1] {
+			t.Errorf("Expected 1 byte and EOF, got %d, %v", n, err)
+		}
+		if n, err = reader.ReadAt(buf, int64(length)); n != 0 || err != io.EOF {
+			t.Errorf("Expected EOF, got %d, %v", n, err)
+		}
+	}
+}
+
+func TestCBCReadSeeker(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 500)
+	rand.Read(plain)
+	var encrypted bytes.Buffer
+	if err := NewAESCBCEncrypter().Encrypt(key, bytes.NewReader(plain), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewCBCReader(key, bytes.NewReader(encrypted.Bytes()), int64(encrypted.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(all, plain) {
+		t.Fatalf("Expected the whole plaintext, got %d bytes, %v", len(all), err)
+	}
+
+	if _, err = reader.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(tail, plain[400:]) {
+		t.Errorf("Expected the last 100 bytes, got %d bytes, %v", len(tail), err)
+	}
+
+	if _, err = reader.Seek(123, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Seek(10, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 20)
+	if _, err = io.ReadFull(reader, buf); err != nil || !bytes.Equal(buf, plain[133:153]) {
+		t.Errorf("Unexpected read after seek: %v", err)
+	}
+
+	if _, err = reader.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Expected an error on a negative position")
+	}
+}
+
+// eofReaderAt returns io.EOF along with the last bytes of the input, as io.ReaderAt allows
+type eofReaderAt struct {
+	*bytes.Reader
+}
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	if err == nil && off+int64(n) == r.Size() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestCBCReaderAtEOF(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := make([]byte, 100)
+	rand.Read(plain)
+	var encrypted bytes.Buffer
+	if err := NewAESCBCEncrypter().Encrypt(key, bytes.NewReader(plain), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewCBCReader(key, eofReaderAt{bytes.NewReader(encrypted.Bytes())}, int64(encrypted.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, err := reader.ReadAt(buf, 90)
+	if err != nil || n != 10 || !bytes.Equal(buf, plain[90:]) {
+		t.Errorf("Expected the last 10 bytes, got %d, %v", n, err)
+	}
+	all, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(all, plain) {
+		t.Errorf("Expected the whole plaintext, got %d bytes, %v", len(all), err)
+	}
+}
+
+func TestCBCReaderInvalid(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := NewCBCReader(key, bytes.NewReader(make([]byte, 16)), 16); err != ErrInvalidCBCResource {
+		t.Errorf("Expected ErrInvalidCBCResource, got %v", err)
+	}
+	if _, err := NewCBCReader(key, bytes.NewReader(make([]byte, 40)), 40); err != ErrInvalidCBCResource {
+		t.Errorf("Expected ErrInvalidCBCResource, got %v", err)
+	}
+}
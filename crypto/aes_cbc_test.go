@@ -133,7 +133,10 @@ func TestKeyWrap(t *testing.T) {
 		0x9D, 0x3E, 0x86, 0x23,
 		0x71, 0xD2, 0xCF, 0xE5}
 
-	out := KeyWrap(key, plain)
+	out, err := KeyWrap(key, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !bytes.Equal(out, expected) {
 		t.Errorf("Expected %x, got %x", expected, out)
 	}
@@ -28,14 +28,13 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"encoding/binary"
+	"crypto/rand"
+	"errors"
 	"io"
 	"io/ioutil"
 )
 
-type gcmEncrypter struct {
-	counter uint64
-}
+type gcmEncrypter struct{}
 
 func (e gcmEncrypter) Signature() string {
 	return "http://www.w3.org/2009/xmlenc11#aes256-gcm"
@@ -46,31 +45,64 @@ func (e gcmEncrypter) GenerateKey() (ContentKey, error) {
 	return ContentKey(slice), err
 }
 
-func (e *gcmEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
-	block, err := aes.NewCipher(key)
+// Encrypt writes the nonce followed by the ciphertext and authentication tag,
+// as specified by the W3C XML Encryption 1.1 AES-GCM algorithm.
+// The nonce is random: the same content key may be reused when a publication is re-encrypted.
+func (e gcmEncrypter) Encrypt(key ContentKey, r io.Reader, w io.Writer) error {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return err
 	}
 
-	counter := e.counter
-	e.counter++
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
 
-	gcm, err := cipher.NewGCM(block)
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
+	out := gcm.Seal(nonce, nonce, data, nil)
 
-	nonce := make([]byte, gcm.NonceSize())
-	binary.BigEndian.PutUint64(nonce, counter)
+	_, err = w.Write(out)
+
+	return err
+}
+
+// Decrypt checks the authentication tag and writes the clear data
+func (e gcmEncrypter) Decrypt(key ContentKey, r io.Reader, w io.Writer) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
 
 	data, err := ioutil.ReadAll(r)
-	out := gcm.Seal(nonce, nonce, data, nil)
+	if err != nil {
+		return err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return errors.New("gcm: encrypted data is too short")
+	}
 
-	_, err = w.Write(out)
+	nonce := data[:gcm.NonceSize()]
+	clear, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
+	if err != nil {
+		return err
+	}
 
+	_, err = w.Write(clear)
 	return err
 }
 
+func newGCM(key ContentKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 func NewAESGCMEncrypter() Encrypter {
-	return &gcmEncrypter{}
-}
\ No newline at end of file
+	return gcmEncrypter(struct{}{})
+}
@@ -29,6 +29,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/hex"
 	"testing"
 )
@@ -66,4 +67,53 @@ func TestEncryptGCM(t *testing.T) {
 		t.Logf("After cycle: %#v", clear)
 		t.Errorf("Expected encryption-decryption to return original")
 	}
-}
\ No newline at end of file
+}
+func TestDecryptGCM(t *testing.T) {
+	key := sha256.Sum256([]byte("password"))
+	data := []byte("The quick brown fox jumps over the lazy dog")
+
+	gcm := NewAESGCMEncrypter()
+	var encrypted bytes.Buffer
+	if err := gcm.Encrypt(key[:], bytes.NewReader(data), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypter, err := NewAESDecrypter(gcm.Signature())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res bytes.Buffer
+	if err = decrypter.Decrypt(key[:], bytes.NewReader(encrypted.Bytes()), &res); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, res.Bytes()) {
+		t.Errorf("Expected %s, got %s", data, res.Bytes())
+	}
+
+	// a modified ciphertext must be rejected
+	tampered := encrypted.Bytes()
+	tampered[len(tampered)-1] ^= 0x01
+	if err = decrypter.Decrypt(key[:], bytes.NewReader(tampered), &res); err == nil {
+		t.Error("Expected an authentication error")
+	}
+}
+
+func TestEncrypterForMode(t *testing.T) {
+	cbc, err := NewAESEncrypterForMode("cbc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cbc.Signature() != "http://www.w3.org/2001/04/xmlenc#aes256-cbc" {
+		t.Errorf("Expected a CBC encrypter, got %s", cbc.Signature())
+	}
+	gcm, err := NewAESEncrypterForMode(ModeGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcm.Signature() != "http://www.w3.org/2009/xmlenc11#aes256-gcm" {
+		t.Errorf("Expected a GCM encrypter, got %s", gcm.Signature())
+	}
+	if _, err = NewAESEncrypterForMode("ECB"); err != ErrUnknownMode {
+		t.Errorf("Expected ErrUnknownMode, got %v", err)
+	}
+}
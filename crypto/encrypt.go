@@ -26,11 +26,12 @@
 package crypto
 
 import (
-	"crypto/aes"
+	"errors"
 	"io"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/config"
 )
-//"github.com/readium/readium-lcp-server/config"
-// FOR: config.Config.AES256_CBC_OR_GCM
 
 type Encrypter interface {
 	Encrypt(key ContentKey, r io.Reader, w io.Writer) error
@@ -42,16 +43,53 @@ type Decrypter interface {
 	Decrypt(key ContentKey, r io.Reader, w io.Writer) error
 }
 
+// Encryption modes which can be selected for publication resources
+const (
+	ModeCBC = "CBC"
+	ModeGCM = "GCM"
+)
+
+var ErrUnknownMode = errors.New("unknown encryption mode, must be CBC or GCM")
+var ErrUnknownAlgorithm = errors.New("unknown encryption algorithm")
+
+// NewAESEncrypter_PUBLICATION_RESOURCES returns the encrypter selected in the configuration, CBC by default.
+// Note that GCM is not supported by every reading system (see https://github.com/readium/readium-lcp-server/issues/109)
 func NewAESEncrypter_PUBLICATION_RESOURCES() Encrypter {
-	
-	return NewAESCBCEncrypter()
 
-	// DISABLED, see https://github.com/readium/readium-lcp-server/issues/109
-	// if config.Config.AES256_CBC_OR_GCM == "GCM" {
-	// 	return NewAESGCMEncrypter()
-	// } else { // default to CBC
-	// 	return NewAESCBCEncrypter()
-	// }
+	encrypter, err := NewAESEncrypterForMode(config.Config.AES256_CBC_OR_GCM)
+	if err != nil {
+		// default to CBC
+		return NewAESCBCEncrypter()
+	}
+	return encrypter
+}
+
+// NewAESEncrypterForMode returns an encrypter for publication resources given a mode (CBC or GCM).
+// An empty mode selects the mode defined in the configuration.
+func NewAESEncrypterForMode(mode string) (Encrypter, error) {
+
+	if mode == "" {
+		mode = config.Config.AES256_CBC_OR_GCM
+	}
+	switch strings.ToUpper(mode) {
+	case "", ModeCBC:
+		return NewAESCBCEncrypter(), nil
+	case ModeGCM:
+		return NewAESGCMEncrypter(), nil
+	}
+	return nil, ErrUnknownMode
+}
+
+// NewAESDecrypter returns a decrypter given the algorithm URI found in an encryption manifest
+func NewAESDecrypter(algorithm string) (Decrypter, error) {
+
+	switch algorithm {
+	case NewAESCBCEncrypter().Signature():
+		return cbcEncrypter(struct{}{}), nil
+	case NewAESGCMEncrypter().Signature():
+		return gcmEncrypter(struct{}{}), nil
+	}
+	return nil, ErrUnknownAlgorithm
 }
 
 func NewAESEncrypter_CONTENT_KEY() Encrypter {
@@ -68,35 +106,3 @@ func NewAESEncrypter_FIELDS() Encrypter {
 	// default to CBC
 	return NewAESEncrypter_CONTENT_KEY()
 }
-
-var (
-	keywrap_iv = []byte{0xa6, 0xa6, 0xa6, 0xa6,
-		0xa6, 0xa6, 0xa6, 0xa6}
-)
-
-func KeyWrap(kek []byte, key []byte) []byte {
-	cipher, _ := aes.NewCipher(kek)
-	n := len(key) / 8
-	r := make([]byte, len(keywrap_iv)+len(key))
-	a := make([]byte, len(keywrap_iv))
-
-	copy(a, keywrap_iv)
-	copy(r[8:], key)
-
-	for j := 0; j < 6; j++ {
-		for i := 1; i <= n; i++ {
-			out := make([]byte, aes.BlockSize)
-			input := make([]byte, aes.BlockSize)
-			copy(input, a)
-			copy(input[8:], r[i*8:(i+1)*8])
-			cipher.Encrypt(out, input)
-			t := n*j + i
-			copy(a, out[0:8])
-			a[7] = a[7] ^ byte(t)
-			copy(r[i*8:], out[8:])
-		}
-	}
-
-	copy(r, a)
-	return r
-}
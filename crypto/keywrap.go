@@ -0,0 +1,177 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// default initial value, RFC 3394 section 2.2.3.1
+	keywrap_iv = []byte{0xa6, 0xa6, 0xa6, 0xa6,
+		0xa6, 0xa6, 0xa6, 0xa6}
+	// alternative initial value prefix, RFC 5649 section 3
+	keywrap_aiv = []byte{0xa6, 0x59, 0x59, 0xa6}
+)
+
+// ErrKeyWrapLength is returned when the key to wrap or unwrap has an unexpected length
+var ErrKeyWrapLength = errors.New("keywrap: invalid key length")
+
+// ErrKeyUnwrapIntegrity is returned when the integrity check value does not match after unwrapping
+var ErrKeyUnwrapIntegrity = errors.New("keywrap: integrity check failed")
+
+// KeyWrap wraps a key using the AES Key Wrap algorithm (RFC 3394).
+// The length of the key must be a multiple of 8 bytes, 16 bytes at least.
+func KeyWrap(kek []byte, key []byte) ([]byte, error) {
+
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(block, keywrap_iv, key), nil
+}
+
+// KeyUnwrap unwraps a key wrapped with the AES Key Wrap algorithm (RFC 3394)
+// and verifies its integrity check value.
+func KeyUnwrap(kek []byte, wrapped []byte) ([]byte, error) {
+
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	a, key := unwrap(block, wrapped)
+	if subtle.ConstantTimeCompare(a, keywrap_iv) != 1 {
+		return nil, ErrKeyUnwrapIntegrity
+	}
+	return key, nil
+}
+
+// KeyWrapWithPadding wraps a key of any length using the AES Key Wrap with Padding algorithm (RFC 5649).
+func KeyWrapWithPadding(kek []byte, key []byte) ([]byte, error) {
+
+	if len(key) == 0 || uint64(len(key)) > 0xffffffff {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	// the alternative initial value embeds the message length indicator
+	aiv := make([]byte, 8)
+	copy(aiv, keywrap_aiv)
+	binary.BigEndian.PutUint32(aiv[4:], uint32(len(key)))
+
+	// pad the key with zeros to a multiple of 8 bytes
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+
+	// a single 64-bit block is encrypted as-is with the alternative initial value
+	if len(padded) == 8 {
+		out := make([]byte, aes.BlockSize)
+		copy(out, aiv)
+		copy(out[8:], padded)
+		block.Encrypt(out, out)
+		return out, nil
+	}
+	return wrap(block, aiv, padded), nil
+}
+
+// KeyUnwrapWithPadding unwraps a key wrapped with the AES Key Wrap with Padding algorithm (RFC 5649)
+// and verifies its alternative initial value and padding.
+func KeyUnwrapWithPadding(kek []byte, wrapped []byte) ([]byte, error) {
+
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var a, padded []byte
+	if len(wrapped) == 16 {
+		out := make([]byte, aes.BlockSize)
+		block.Decrypt(out, wrapped)
+		a, padded = out[:8], out[8:]
+	} else {
+		a, padded = unwrap(block, wrapped)
+	}
+
+	// check the alternative initial value, the message length indicator and the padding
+	if subtle.ConstantTimeCompare(a[:4], keywrap_aiv) != 1 {
+		return nil, ErrKeyUnwrapIntegrity
+	}
+	mli := int(binary.BigEndian.Uint32(a[4:]))
+	if mli > len(padded) || mli <= len(padded)-8 {
+		return nil, ErrKeyUnwrapIntegrity
+	}
+	for _, b := range padded[mli:] {
+		if b != 0 {
+			return nil, ErrKeyUnwrapIntegrity
+		}
+	}
+	return padded[:mli], nil
+}
+
+// wrap applies the wrapping process defined in RFC 3394 section 2.2.1 to a plaintext of n 64-bit blocks
+func wrap(block cipher.Block, iv []byte, plain []byte) []byte {
+
+	n := len(plain) / 8
+	r := make([]byte, 8+len(plain))
+	copy(r[8:], plain)
+	a := make([]byte, 8)
+	copy(a, iv)
+
+	b := make([]byte, aes.BlockSize)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, a)
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i*8:], b[8:])
+		}
+	}
+
+	copy(r, a)
+	return r
+}
+
+// unwrap applies the unwrapping process defined in RFC 3394 section 2.2.2,
+// and returns the recovered initial value and plaintext
+func unwrap(block cipher.Block, wrapped []byte) ([]byte, []byte) {
+
+	n := len(wrapped)/8 - 1
+	r := make([]byte, len(wrapped))
+	copy(r, wrapped)
+	a := make([]byte, 8)
+	copy(a, r[:8])
+
+	b := make([]byte, aes.BlockSize)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[i*8:], b[8:])
+		}
+	}
+
+	return a, r[8:]
+}
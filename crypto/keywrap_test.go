@@ -0,0 +1,135 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// test vectors from RFC 3394 section 4
+func TestKeyWrapRFC3394(t *testing.T) {
+	vectors := []struct {
+		kek, key, wrapped string
+	}{
+		{"000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{"000102030405060708090A0B0C0D0E0F1011121314151617", "00112233445566778899AABBCCDDEEFF0001020304050607",
+			"031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2"},
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+	}
+	for _, v := range vectors {
+		kek, key, expected := decodeHex(t, v.kek), decodeHex(t, v.key), decodeHex(t, v.wrapped)
+
+		wrapped, err := KeyWrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Errorf("Expected %x, got %x", expected, wrapped)
+		}
+
+		unwrapped, err := KeyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("Expected %x, got %x", key, unwrapped)
+		}
+	}
+}
+
+func TestKeyUnwrapIntegrity(t *testing.T) {
+	kek := decodeHex(t, "000102030405060708090A0B0C0D0E0F")
+	wrapped := decodeHex(t, "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped[10] ^= 0x01
+	if _, err := KeyUnwrap(kek, wrapped); err != ErrKeyUnwrapIntegrity {
+		t.Errorf("Expected an integrity error, got %v", err)
+	}
+	// a wrong key-encryption key must be detected as well
+	other := decodeHex(t, "0F0E0D0C0B0A09080706050403020100")
+	if _, err := KeyUnwrap(other, wrapped); err != ErrKeyUnwrapIntegrity {
+		t.Errorf("Expected an integrity error, got %v", err)
+	}
+}
+
+func TestKeyWrapErrors(t *testing.T) {
+	if _, err := KeyWrap([]byte("short"), make([]byte, 16)); err == nil {
+		t.Error("Expected an error from an invalid key-encryption key")
+	}
+	if _, err := KeyWrap(make([]byte, 16), make([]byte, 20)); err != ErrKeyWrapLength {
+		t.Errorf("Expected ErrKeyWrapLength, got %v", err)
+	}
+	if _, err := KeyUnwrap(make([]byte, 16), make([]byte, 16)); err != ErrKeyWrapLength {
+		t.Errorf("Expected ErrKeyWrapLength, got %v", err)
+	}
+}
+
+// test vectors from RFC 5649 section 6
+func TestKeyWrapWithPaddingRFC5649(t *testing.T) {
+	kek := decodeHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	vectors := []struct {
+		key, wrapped string
+	}{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	}
+	for _, v := range vectors {
+		key, expected := decodeHex(t, v.key), decodeHex(t, v.wrapped)
+
+		wrapped, err := KeyWrapWithPadding(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Errorf("Expected %x, got %x", expected, wrapped)
+		}
+
+		unwrapped, err := KeyUnwrapWithPadding(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("Expected %x, got %x", key, unwrapped)
+		}
+
+		wrapped[len(wrapped)-1] ^= 0x01
+		if _, err = KeyUnwrapWithPadding(kek, wrapped); err != ErrKeyUnwrapIntegrity {
+			t.Errorf("Expected an integrity error, got %v", err)
+		}
+	}
+}
+
+func TestKeyWrapWithPaddingLengths(t *testing.T) {
+	kek, err := GenerateKey(aes256keyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size := 1; size <= 40; size++ {
+		key, _ := GenerateKey(size)
+		wrapped, err := KeyWrapWithPadding(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unwrapped, err := KeyUnwrapWithPadding(kek, wrapped)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("size %d: expected %x, got %x", size, key, unwrapped)
+		}
+	}
+}
@@ -4,7 +4,29 @@ CREATE TABLE `content` (
     `location` text NOT NULL,
     `length` bigint(20),
     `sha256` varchar(64),
-    `type` varchar(255) NOT NULL DEFAULT 'application/epub+zip'
+    `type` varchar(255) NOT NULL DEFAULT 'application/epub+zip',
+    `encryption_algorithm` varchar(255) NOT NULL DEFAULT '',
+    `key_version` int(11) NOT NULL DEFAULT 0,
+    `tenant` varchar(255) NOT NULL DEFAULT '',
+    `title` varchar(1024) NOT NULL DEFAULT '',
+    `authors` varchar(2048) NOT NULL DEFAULT '',
+    `language` varchar(64) NOT NULL DEFAULT '',
+    `identifier` varchar(255) NOT NULL DEFAULT '',
+    `publisher` varchar(255) NOT NULL DEFAULT '',
+    `version` int(11) NOT NULL DEFAULT 1,
+    `withdrawn` datetime DEFAULT NULL
+);
+
+CREATE TABLE `content_version` (
+    `content_id` varchar(255) NOT NULL,
+    `version` int(11) NOT NULL,
+    `location` text NOT NULL,
+    `length` bigint(20),
+    `sha256` varchar(64),
+    `type` varchar(255) NOT NULL DEFAULT 'application/epub+zip',
+    `encryption_algorithm` varchar(255) NOT NULL DEFAULT '',
+    `archived` datetime NOT NULL,
+    PRIMARY KEY (`content_id`, `version`)
 );
 
 CREATE TABLE `license` (
@@ -19,5 +41,25 @@ CREATE TABLE `license` (
     `rights_end` datetime DEFAULT NULL,
     `content_fk` varchar(255) NOT NULL,
     `lsd_status` int(11) default 0,
+    `user_extensions` text DEFAULT NULL,
+    `tenant` varchar(255) NOT NULL DEFAULT '',
+    `profile` varchar(255) NOT NULL DEFAULT '',
     FOREIGN KEY(content_fk) REFERENCES content(id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE `license_history` (
+    `id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    `license_id` varchar(255) NOT NULL,
+    `updated` datetime NOT NULL,
+    `caller` varchar(255) NOT NULL DEFAULT '',
+    `origin` varchar(32) NOT NULL,
+    `previous_print` int(11) DEFAULT NULL,
+    `previous_copy` int(11) DEFAULT NULL,
+    `previous_start` datetime DEFAULT NULL,
+    `previous_end` datetime DEFAULT NULL,
+    `new_print` int(11) DEFAULT NULL,
+    `new_copy` int(11) DEFAULT NULL,
+    `new_start` datetime DEFAULT NULL,
+    `new_end` datetime DEFAULT NULL,
+    FOREIGN KEY(license_id) REFERENCES license(id)
+);
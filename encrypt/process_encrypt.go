@@ -7,7 +7,11 @@ package encrypt
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -29,7 +33,8 @@ import (
 
 // ProcessEncryption encrypts a publication
 // inputPath must contain a processable file extension (EPUB, PDF, LPF or RPF)
-func ProcessEncryption(contentID, contentKey, inputPath, tempRepo, outputRepo, storageRepo, storageURL, storageFilename string) (*apilcp.LcpPublication, error) {
+// encryptionMode selects the encryption of resources (CBC or GCM); if empty, the mode set in the configuration is used.
+func ProcessEncryption(contentID, contentKey, inputPath, tempRepo, outputRepo, storageRepo, storageURL, storageFilename, encryptionMode string) (*apilcp.LcpPublication, error) {
 
 	if inputPath == "" {
 		return nil, errors.New("ProcessEncryption, parameter error")
@@ -107,7 +112,11 @@ func ProcessEncryption(contentID, contentKey, inputPath, tempRepo, outputRepo, s
 	outputPath := filepath.Join(outputRepo, storageFilename)
 
 	// define an AES encrypter
-	encrypter := crypto.NewAESEncrypter_PUBLICATION_RESOURCES()
+	encrypter, err := crypto.NewAESEncrypterForMode(encryptionMode)
+	if err != nil {
+		return nil, err
+	}
+	pub.EncryptionAlgorithm = encrypter.Signature()
 
 	// select the encryption process from the input file extension
 	err = nil
@@ -322,6 +331,8 @@ func processEPUB(pub *apilcp.LcpPublication, inputPath string, outputPath string
 		return err
 	}
 	pub.ContentKey = encryptionKey
+	metadata := pack.EPUBMetadata(epub)
+	pub.Metadata = &metadata
 	// calculate the output file size and checksum
 	stats, err := outputFile.Stat()
 	if err == nil && (stats.Size() > 0) {
@@ -404,6 +415,8 @@ func buildEncryptedRPF(pub *apilcp.LcpPublication, inputPath string, outputPath
 		return err
 	}
 	pub.ContentKey = encryptionKey
+	metadata := reader.Metadata()
+	pub.Metadata = &metadata
 
 	err = writer.Close()
 	if err != nil {
@@ -460,3 +473,52 @@ func NotifyLcpServer(pub *apilcp.LcpPublication, licenseServerURL string, userna
 
 	return nil
 }
+
+// FetchContentKey gets the content key of a content from the License Server, base64 encoded,
+// so that a new version of the content is encrypted with the same key.
+// The key is wrapped by the License Server with a RSA key generated for this request, so that it is never sent in clear.
+// It returns an empty string if the content is unknown to the License Server.
+func FetchContentKey(contentID string, licenseServerURL string, username string, password string) (string, error) {
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(apilcp.ContentKeyRequest{PublicKey: publicKey})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", licenseServerURL+"/contents/"+contentID+"/key", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("lcp server error %d", resp.StatusCode)
+	}
+	var key apilcp.WrappedContentKey
+	if err = json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return "", err
+	}
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, key.WrappedKey, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(contentKey), nil
+}
@@ -7,24 +7,47 @@ package opf
 import (
 	"encoding/xml"
 	"io"
+	"strings"
 
 	"golang.org/x/net/html/charset"
 )
 
 // Package is the main opf structure
 type Package struct {
-	BasePath string   `xml:"-"`
-	Metadata Metadata `xml:"http://www.idpf.org/2007/opf metadata"`
-	Manifest Manifest `xml:"http://www.idpf.org/2007/opf manifest"`
+	BasePath         string   `xml:"-"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Metadata         Metadata `xml:"http://www.idpf.org/2007/opf metadata"`
+	Manifest         Manifest `xml:"http://www.idpf.org/2007/opf manifest"`
 }
 
 // Metadata is the package metadata structure
 type Metadata struct {
-	Author string `json:"author" xml:"http://purl.org/dc/elements/1.1/ creator"`
-	Title  string `json:"title" xml:"http://purl.org/dc/elements/1.1/ title"`
-	Isbn   string `json:"isbn" xml:"http://purl.org/dc/elements/1.1/ identifier"`
-	Metas  []Meta `xml:"http://www.idpf.org/2007/opf meta"`
-	Cover  string `json:"cover"`
+	Creators    []string     `json:"creators" xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Titles      []string     `json:"titles" xml:"http://purl.org/dc/elements/1.1/ title"`
+	Identifiers []Identifier `json:"identifiers" xml:"http://purl.org/dc/elements/1.1/ identifier"`
+	Languages   []string     `json:"languages" xml:"http://purl.org/dc/elements/1.1/ language"`
+	Publishers  []string     `json:"publishers" xml:"http://purl.org/dc/elements/1.1/ publisher"`
+	Metas       []Meta       `xml:"http://www.idpf.org/2007/opf meta"`
+	Cover       string       `json:"cover"`
+}
+
+// Identifier is a dc:identifier element
+type Identifier struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Identifier returns the unique identifier of the package, the first identifier by default
+func (p Package) Identifier() string {
+	for _, id := range p.Metadata.Identifiers {
+		if id.ID == p.UniqueIdentifier {
+			return strings.TrimSpace(id.Value)
+		}
+	}
+	if len(p.Metadata.Identifiers) > 0 {
+		return strings.TrimSpace(p.Metadata.Identifiers[0].Value)
+	}
+	return ""
 }
 
 // Meta is the metadata item structure
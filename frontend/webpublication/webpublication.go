@@ -138,7 +138,7 @@ func encryptPublication(inputPath string, pub Publication, pubManager Publicatio
 	// FIXME: work on a direct storage of the output file.
 	outputRepo := pubManager.config.FrontendServer.EncryptedRepository
 	empty := ""
-	notification, err := encrypt.ProcessEncryption(empty, empty, inputPath, empty, outputRepo, empty, empty, empty)
+	notification, err := encrypt.ProcessEncryption(empty, empty, inputPath, empty, outputRepo, empty, empty, empty, empty)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,162 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/readium/readium-lcp-server/storage"
+)
+
+// Statuses of the storage items reported by an audit
+const (
+	// the item of a content version is not in the storage
+	AuditMissing = "missing"
+	// the item is shorter than the indexed length
+	AuditTruncated = "truncated"
+	// the item has the wrong hash, or is longer than the indexed length
+	AuditCorrupted = "corrupted"
+	// the item could not be read
+	AuditUnreadable = "unreadable"
+)
+
+// AuditIssue is a storage item which does not match its index row
+type AuditIssue struct {
+	ContentID      string `json:"content_id"`
+	Version        int    `json:"version"`
+	Key            string `json:"key"`
+	Status         string `json:"status"`
+	ExpectedLength int64  `json:"expected_length,omitempty"`
+	ActualLength   int64  `json:"actual_length,omitempty"`
+	ExpectedSha256 string `json:"expected_sha256,omitempty"`
+	ActualSha256   string `json:"actual_sha256,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// AuditReport is the result of a storage audit
+type AuditReport struct {
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Checked  int       `json:"checked"`
+	// number of versions stored by the encryption tool outside the storage of the server, which are not checked
+	External int          `json:"external"`
+	Issues   []AuditIssue `json:"issues"`
+	// keys of the storage items which match no content version
+	Orphans []string `json:"orphans"`
+}
+
+// Audit checks every version of the contents of the given indexes against a storage:
+// each item is read to recompute its length and SHA-256 hash, which are compared to the indexed ones.
+// The versions stored outside the storage of the server, and the withdrawn contents, are not checked.
+// Several indexes are given when tenants share the storage; the items which match no content version
+// of these indexes are reported as orphans.
+func Audit(store storage.Store, indexes ...Index) (AuditReport, error) {
+
+	start := time.Now()
+	report := AuditReport{Started: start.UTC().Truncate(time.Second), Issues: []AuditIssue{}, Orphans: []string{}}
+	expected := make(map[string]bool)
+	for _, idx := range indexes {
+		// list the rows first, as the list query keeps a connection busy
+		var ids []string
+		fn := idx.List()
+		c, err := fn()
+		for ; err == nil; c, err = fn() {
+			// the files of a withdrawn content are removed
+			if c.Withdrawn == nil {
+				ids = append(ids, c.ID)
+			}
+		}
+		if err != ErrNotFound {
+			return report, err
+		}
+
+		for _, id := range ids {
+			versions, err := idx.Versions(id)
+			if err == ErrNotFound {
+				// deleted in the meantime
+				continue
+			} else if err != nil {
+				return report, err
+			}
+			for _, v := range versions {
+				expected[v.StorageKey()] = true
+				if isExternal(v) {
+					report.External++
+					continue
+				}
+				report.Checked++
+				if issue := auditVersion(store, v); issue != nil {
+					report.Issues = append(report.Issues, *issue)
+				}
+			}
+		}
+	}
+
+	items, err := store.List()
+	if err != nil && err != storage.ErrNotFound {
+		return report, err
+	}
+	for _, item := range items {
+		if !expected[item.Key()] {
+			report.Orphans = append(report.Orphans, item.Key())
+		}
+	}
+	sort.Strings(report.Orphans)
+	report.Duration = time.Since(start).Truncate(time.Millisecond).String()
+	return report, nil
+}
+
+// isExternal checks if the file of a version is stored outside the storage of the server:
+// the encryption tool then indexes the url of the file as its location, and the file is not archived by the server.
+func isExternal(v Version) bool {
+	u, err := url.Parse(v.Location)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// auditVersion streams the item of a content version, and returns an issue if it does not match the version
+func auditVersion(store storage.Store, v Version) *AuditIssue {
+
+	issue := &AuditIssue{ContentID: v.ContentID, Version: v.Version, Key: v.StorageKey(),
+		ExpectedLength: v.Length, ExpectedSha256: v.Sha256}
+	item, err := store.Get(issue.Key)
+	if err == storage.ErrNotFound {
+		issue.Status = AuditMissing
+		return issue
+	} else if err != nil {
+		issue.Status, issue.Error = AuditUnreadable, err.Error()
+		return issue
+	}
+	contents, err := item.Contents()
+	if err != nil {
+		issue.Status, issue.Error = AuditUnreadable, err.Error()
+		return issue
+	}
+	defer contents.Close()
+	hasher := sha256.New()
+	issue.ActualLength, err = io.Copy(hasher, contents)
+	if err != nil {
+		issue.Status, issue.Error = AuditUnreadable, err.Error()
+		return issue
+	}
+	issue.ActualSha256 = hex.EncodeToString(hasher.Sum(nil))
+
+	// the length and hash are not indexed for every content
+	switch {
+	case v.Length > 0 && issue.ActualLength < v.Length:
+		issue.Status = AuditTruncated
+	case v.Length > 0 && issue.ActualLength > v.Length:
+		issue.Status = AuditCorrupted
+	case v.Sha256 != "" && issue.ActualSha256 != v.Sha256:
+		issue.Status = AuditCorrupted
+	default:
+		return nil
+	}
+	return issue
+}
@@ -27,8 +27,10 @@ package index
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/readium/readium-lcp-server/config"
 )
@@ -36,12 +38,47 @@ import (
 // ErrNotFound signals content not found
 var ErrNotFound = errors.New("Content not found")
 
+// ErrIDConflict signals a content id already used by another tenant
+var ErrIDConflict = errors.New("Content id already used")
+
+// ErrWithdrawn signals a content which has been withdrawn
+var ErrWithdrawn = errors.New("Content withdrawn")
+
 // Index is an interface
 type Index interface {
 	Get(id string) (Content, error)
 	Add(c Content) error
 	Update(c Content) error
+	Delete(id string) error
+	Withdraw(id string) error
 	List() func() (Content, error)
+	Find(f Filter, page int, pageNum int) func() (Content, error)
+	Tenant(id string) (Index, error)
+	TenantOf(id string) (string, error)
+	AddVersion(c Content) (int, error)
+	Versions(id string) ([]Version, error)
+}
+
+// Metadata holds descriptive metadata of a publication, extracted from its OPF or RWPM manifest
+type Metadata struct {
+	Title      string   `json:"title,omitempty"`
+	Authors    []string `json:"authors,omitempty"`
+	Language   string   `json:"language,omitempty"`
+	Identifier string   `json:"identifier,omitempty"`
+	Publisher  string   `json:"publisher,omitempty"`
+}
+
+// Filter selects contents by their metadata and type; empty criteria match every content.
+// Search is matched against the title, authors, identifier and publisher; other text criteria are
+// case-insensitive substrings, except the language and type which must be equal.
+type Filter struct {
+	Search     string
+	Title      string
+	Author     string
+	Publisher  string
+	Identifier string
+	Language   string
+	Type       string
 }
 
 // Content represents an encrypted resource
@@ -52,66 +89,275 @@ type Content struct {
 	Length        int64  `json:"length"` //not exported in license spec?
 	Sha256        string `json:"sha256"` //not exported in license spec?
 	Type          string `json:"type"`
+	// algorithm of the publication resources; empty means the default (aes256-cbc)
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"`
+	// version of the master key protecting the content key in the database, KeyClear if not protected
+	KeyVersion int `json:"-"`
+	// version of the encrypted file, incremented each time the publication is re-encrypted with the same key
+	Version int `json:"version"`
+	// date the content was withdrawn; the row is kept for the licenses of the content
+	Withdrawn *time.Time `json:"withdrawn,omitempty"`
+	Metadata
 }
 
 type dbIndex struct {
-	db   *sql.DB
-	get  *sql.Stmt
-	add  *sql.Stmt
-	list *sql.Stmt
+	db         *sql.DB
+	get        *sql.Stmt
+	add        *sql.Stmt
+	list       *sql.Stmt
+	masterKeys MasterKeys
+	// tenant the index is restricted to, if scoped
+	tenant string
+	scoped bool
+}
+
+// tenantArgs appends the tenant to query arguments if the index is scoped
+func (i dbIndex) tenantArgs(args ...interface{}) []interface{} {
+	if i.scoped {
+		args = append(args, i.tenant)
+	}
+	return args
+}
+
+// tenantCond returns the sql condition restricting a query to the tenant, if the index is scoped
+func (i dbIndex) tenantCond(op string) string {
+	if i.scoped {
+		return " " + op + " tenant = ?"
+	}
+	return ""
+}
+
+// Tenant returns a view of the index restricted to the contents of a tenant.
+// The default tenant has an empty id.
+func (i dbIndex) Tenant(id string) (Index, error) {
+	t := i
+	t.tenant, t.scoped = id, true
+	var err error
+	t.get, err = i.db.Prepare("SELECT " + contentColumns + " FROM content WHERE id = ?" + t.tenantCond("AND") + " LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	t.list, err = i.db.Prepare("SELECT " + contentColumns + " FROM content" + t.tenantCond("WHERE"))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TenantOf returns the tenant of a visible content
+func (i dbIndex) TenantOf(id string) (string, error) {
+	var tenant string
+	err := i.db.QueryRow("SELECT tenant FROM content WHERE id=?"+i.tenantCond("AND"), i.tenantArgs(id)...).Scan(&tenant)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return tenant, err
 }
 
 func (i dbIndex) Get(id string) (Content, error) {
-	records, err := i.get.Query(id)
+	records, err := i.get.Query(i.tenantArgs(id)...)
+	if err != nil {
+		return Content{}, err
+	}
 	defer records.Close()
 	if records.Next() {
-		var c Content
-		err = records.Scan(&c.ID, &c.EncryptionKey, &c.Location, &c.Length, &c.Sha256, &c.Type)
-		return c, err
+		return i.scanContent(records)
 	}
 
 	return Content{}, ErrNotFound
 }
 
+// scanContent scans a content row selected with contentColumns
+func (i dbIndex) scanContent(rows *sql.Rows) (Content, error) {
+	var c Content
+	var authors string
+	err := rows.Scan(&c.ID, &c.EncryptionKey, &c.Location, &c.Length, &c.Sha256, &c.Type, &c.EncryptionAlgorithm, &c.KeyVersion,
+		&c.Title, &authors, &c.Language, &c.Identifier, &c.Publisher, &c.Version, &c.Withdrawn)
+	if err != nil {
+		return c, err
+	}
+	if authors != "" {
+		if err = json.Unmarshal([]byte(authors), &c.Authors); err != nil {
+			return c, err
+		}
+	}
+	err = i.unprotectKey(&c)
+	return c, err
+}
+
+// storedAuthors returns the authors of a content as stored in the db, a json array
+func storedAuthors(c Content) string {
+	if len(c.Authors) == 0 {
+		return ""
+	}
+	authors, _ := json.Marshal(c.Authors)
+	return string(authors)
+}
+
+// Add adds a content to the index.
+// As content ids are unique across tenants, ErrIDConflict is returned if the id is used by another tenant.
 func (i dbIndex) Add(c Content) error {
-	add, err := i.db.Prepare("INSERT INTO content (id,encryption_key,location,length,sha256,type) VALUES (?, ?, ?, ?, ?, ?)")
+	if i.scoped {
+		var tenant string
+		err := i.db.QueryRow("SELECT tenant FROM content WHERE id=?", c.ID).Scan(&tenant)
+		if err == nil && tenant != i.tenant {
+			return ErrIDConflict
+		} else if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	add, err := i.db.Prepare(`INSERT INTO content (id,encryption_key,location,length,sha256,type,encryption_algorithm,key_version,
+		title,authors,language,identifier,publisher,version,tenant) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer add.Close()
-	_, err = add.Exec(c.ID, c.EncryptionKey, c.Location, c.Length, c.Sha256, c.Type)
+	if err = i.protectKey(&c); err != nil {
+		return err
+	}
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	_, err = add.Exec(c.ID, c.EncryptionKey, c.Location, c.Length, c.Sha256, c.Type, c.EncryptionAlgorithm, c.KeyVersion,
+		c.Title, storedAuthors(c), c.Language, c.Identifier, c.Publisher, c.Version, i.tenant)
 	return err
 }
 
 func (i dbIndex) Update(c Content) error {
-	add, err := i.db.Prepare("UPDATE content SET encryption_key=? , location=?, length=?, sha256=?, type=? WHERE id=?")
+	add, err := i.db.Prepare(`UPDATE content SET encryption_key=? , location=?, length=?, sha256=?, type=?, encryption_algorithm=?, key_version=?,
+		title=?, authors=?, language=?, identifier=?, publisher=? WHERE id=?` + i.tenantCond("AND"))
 	if err != nil {
 		return err
 	}
 	defer add.Close()
-	_, err = add.Exec(c.EncryptionKey, c.Location, c.Length, c.Sha256, c.Type, c.ID)
+	if err = i.protectKey(&c); err != nil {
+		return err
+	}
+	_, err = add.Exec(i.tenantArgs(c.EncryptionKey, c.Location, c.Length, c.Sha256, c.Type, c.EncryptionAlgorithm, c.KeyVersion,
+		c.Title, storedAuthors(c), c.Language, c.Identifier, c.Publisher, c.ID)...)
 	return err
 }
 
+func (i dbIndex) Delete(id string) error {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM content WHERE id=?"+i.tenantCond("AND"), i.tenantArgs(id)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	// previous versions of the content
+	if _, err = tx.Exec("DELETE FROM content_version WHERE content_id=?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Withdraw marks a content as withdrawn and deletes its previous versions.
+// The content row, which holds the content key, is kept so that the licenses of the content can still be built.
+func (i dbIndex) Withdraw(id string) error {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE content SET withdrawn=? WHERE id=? AND withdrawn IS NULL"+i.tenantCond("AND"),
+		i.tenantArgs(time.Now().UTC().Truncate(time.Second), id)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err = tx.Exec("DELETE FROM content_version WHERE content_id=?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (i dbIndex) List() func() (Content, error) {
-	rows, err := i.list.Query()
+	rows, err := i.list.Query(i.tenantArgs()...)
+	if err != nil {
+		return func() (Content, error) { return Content{}, err }
+	}
+	return i.iterate(rows)
+}
+
+// Find lists the contents selected by a filter, ordered by title then id.
+// page is the number of contents per page, 0 for no limit; pageNum starts at 0.
+func (i dbIndex) Find(f Filter, page int, pageNum int) func() (Content, error) {
+	var conds []string
+	var args []interface{}
+	contains := func(value string, columns ...string) {
+		if value == "" {
+			return
+		}
+		var or []string
+		for _, column := range columns {
+			or = append(or, "LOWER("+column+") LIKE ? ESCAPE '!'")
+			args = append(args, "%"+likeEscaper.Replace(strings.ToLower(value))+"%")
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+	equals := func(value string, column string) {
+		if value == "" {
+			return
+		}
+		conds = append(conds, "LOWER("+column+") = ?")
+		args = append(args, strings.ToLower(value))
+	}
+	contains(f.Search, "title", "authors", "identifier", "publisher")
+	contains(f.Title, "title")
+	contains(f.Author, "authors")
+	contains(f.Publisher, "publisher")
+	contains(f.Identifier, "identifier")
+	equals(f.Language, "language")
+	equals(f.Type, "type")
+	// withdrawn contents are not listed
+	conds = append(conds, "withdrawn IS NULL")
+	if i.scoped {
+		conds = append(conds, "tenant = ?")
+		args = append(args, i.tenant)
+	}
+
+	query := "SELECT " + contentColumns + " FROM content WHERE " + strings.Join(conds, " AND ")
+	query += " ORDER BY title, id"
+	if page > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, page, pageNum*page)
+	}
+	rows, err := i.db.Query(query, args...)
 	if err != nil {
 		return func() (Content, error) { return Content{}, err }
 	}
+	return i.iterate(rows)
+}
+
+// iterate returns an iterator on content rows
+func (i dbIndex) iterate(rows *sql.Rows) func() (Content, error) {
 	return func() (Content, error) {
-		var c Content
-		var err error
 		if rows.Next() {
-			err = rows.Scan(&c.ID, &c.EncryptionKey, &c.Location, &c.Length, &c.Sha256, &c.Type)
-		} else {
-			rows.Close()
-			err = ErrNotFound
+			return i.scanContent(rows)
 		}
-		return c, err
+		rows.Close()
+		return Content{}, ErrNotFound
 	}
 }
 
-// Open opens an SQL database
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// columns of the content table read in a Content
+const contentColumns = "id,encryption_key,location,length,sha256,type,encryption_algorithm,key_version,title,authors,language,identifier,publisher,version,withdrawn"
+
+// Open opens the content index.
+// If a master key is set in the configuration, content keys are stored encrypted with this key;
+// previous master keys are used for reading content keys which have not been rotated yet.
 func Open(db *sql.DB) (i Index, err error) {
 	// if sqlite, create the content table in the lcp db if it does not exist
 	if strings.HasPrefix(config.Config.LcpServer.Database, "sqlite") {
@@ -120,17 +366,35 @@ func Open(db *sql.DB) (i Index, err error) {
 			return
 		}
 		db.Exec("ALTER TABLE content ADD COLUMN \"type\" varchar(255) NOT NULL DEFAULT 'application/epub+zip'")
+		db.Exec("ALTER TABLE content ADD COLUMN encryption_algorithm varchar(255) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN key_version integer NOT NULL DEFAULT 0")
+		db.Exec("ALTER TABLE content ADD COLUMN tenant varchar(255) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN title text NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN authors text NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN language varchar(64) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN identifier varchar(255) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN publisher varchar(255) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE content ADD COLUMN version integer NOT NULL DEFAULT 1")
+		db.Exec("ALTER TABLE content ADD COLUMN withdrawn datetime DEFAULT NULL")
+		if _, err = db.Exec(versionTableDef); err != nil {
+			return
+		}
+	}
+
+	masterKeys, err := LoadMasterKeys()
+	if err != nil {
+		return
 	}
 
-	get, err := db.Prepare("SELECT id,encryption_key,location,length,sha256,type FROM content WHERE id = ? LIMIT 1")
+	get, err := db.Prepare("SELECT " + contentColumns + " FROM content WHERE id = ? LIMIT 1")
 	if err != nil {
 		return
 	}
-	list, err := db.Prepare("SELECT id,encryption_key,location,length,sha256,type FROM content")
+	list, err := db.Prepare("SELECT " + contentColumns + " FROM content")
 	if err != nil {
 		return
 	}
-	i = dbIndex{db, get, nil, list}
+	i = dbIndex{db: db, get: get, list: list, masterKeys: masterKeys}
 	return
 }
 
@@ -140,4 +404,14 @@ const tableDef = "CREATE TABLE IF NOT EXISTS content (" +
 	"location text NOT NULL," +
 	"length bigint," +
 	"sha256 varchar(64)," +
-	"\"type\" varchar(256) NOT NULL default 'application/epub+zip')"
+	"\"type\" varchar(256) NOT NULL default 'application/epub+zip'," +
+	"encryption_algorithm varchar(255) NOT NULL default ''," +
+	"key_version integer NOT NULL default 0," +
+	"tenant varchar(255) NOT NULL default ''," +
+	"title text NOT NULL default ''," +
+	"authors text NOT NULL default ''," +
+	"language varchar(64) NOT NULL default ''," +
+	"identifier varchar(255) NOT NULL default ''," +
+	"publisher varchar(255) NOT NULL default ''," +
+	"version integer NOT NULL default 1," +
+	"withdrawn datetime DEFAULT NULL)"
@@ -5,12 +5,19 @@
 package index
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/storage"
 )
 
 func TestIndexCreation(t *testing.T) {
@@ -34,3 +41,588 @@ func TestIndexCreation(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestContentKeyProtection(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+	config.Config.ContentKeyEncryption.MasterKey = ""
+	defer func() { config.Config.ContentKeyEncryption.MasterKey = "" }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// a content key stored in clear, before a master key is configured
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clearKey := bytes.Repeat([]byte{0x42}, 32)
+	if err = idx.Add(Content{ID: "clear", EncryptionKey: clearKey, Location: "clear.epub"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Config.ContentKeyEncryption.MasterKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	idx, err = Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappedKey := bytes.Repeat([]byte{0x24}, 32)
+	if err = idx.Add(Content{ID: "wrapped", EncryptionKey: wrappedKey, Location: "wrapped.epub"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is not stored in clear
+	var stored []byte
+	if err = db.QueryRow("SELECT encryption_key FROM content WHERE id='wrapped'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(stored, wrappedKey) {
+		t.Error("Expected the content key to be stored wrapped")
+	}
+
+	// both keys are transparently returned in clear
+	for id, key := range map[string][]byte{"clear": clearKey, "wrapped": wrappedKey} {
+		c, err := idx.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.EncryptionKey, key) {
+			t.Errorf("Expected %x, got %x", key, c.EncryptionKey)
+		}
+	}
+
+	// migrate the remaining clear key
+	count, err := ProtectContentKeys(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 protected key, got %d", count)
+	}
+	fn := idx.List()
+	for c, err := fn(); err != ErrNotFound; c, err = fn() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.KeyVersion != 1 {
+			t.Errorf("Expected the key of %s to be wrapped", c.ID)
+		}
+	}
+	c, err := idx.Get("clear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.EncryptionKey, clearKey) {
+		t.Errorf("Expected %x, got %x", clearKey, c.EncryptionKey)
+	}
+
+	// protected keys cannot be read without the master key
+	config.Config.ContentKeyEncryption.MasterKey = ""
+	idx, err = Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = idx.Get("wrapped"); err != ErrMasterKeyMissing {
+		t.Errorf("Expected ErrMasterKeyMissing, got %v", err)
+	}
+}
+
+func TestContentKeyRotation(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+	keyConfig := &config.Config.ContentKeyEncryption
+	defer func() { *keyConfig = config.ContentKeyEncryption{} }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// content keys protected with the first master key
+	*keyConfig = config.ContentKeyEncryption{MasterKey: "000102030405060708090a0b0c0d0e0f"}
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string][]byte{}
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		keys[id] = bytes.Repeat([]byte(id), 32)
+		if err = idx.Add(Content{ID: id, EncryptionKey: keys[id], Location: id + ".epub"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// during the rollover, keys protected with both master keys are readable
+	*keyConfig = config.ContentKeyEncryption{
+		MasterKey:          "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		MasterKeyVersion:   2,
+		PreviousMasterKeys: []config.MasterKey{{Version: 1, Key: "000102030405060708090a0b0c0d0e0f"}},
+	}
+	idx, err = Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys["f"] = bytes.Repeat([]byte("f"), 32)
+	if err = idx.Add(Content{ID: "f", EncryptionKey: keys["f"], Location: "f.epub"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, key := range keys {
+		c, err := idx.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.EncryptionKey, key) {
+			t.Errorf("Expected %x, got %x", key, c.EncryptionKey)
+		}
+	}
+
+	var batches []int
+	count, err := RotateContentKeys(idx, 2, func(done, total int) {
+		if total != 5 {
+			t.Errorf("Expected 5 keys to rotate, got %d", total)
+		}
+		batches = append(batches, done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || len(batches) != 3 || batches[2] != 5 {
+		t.Errorf("Unexpected rotation: %d keys, batches %v", count, batches)
+	}
+
+	// a second run has nothing left to do
+	if count, err = RotateContentKeys(idx, 2, nil); err != nil || count != 0 {
+		t.Errorf("Expected no key to rotate, got %d, %v", count, err)
+	}
+
+	// the previous master key is not needed anymore
+	keyConfig.PreviousMasterKeys = nil
+	idx, err = Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, key := range keys {
+		c, err := idx.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.KeyVersion != 2 || !bytes.Equal(c.EncryptionKey, key) {
+			t.Errorf("Expected %x with version 2, got %x with version %d", key, c.EncryptionKey, c.KeyVersion)
+		}
+	}
+}
+
+func TestContentKeyRotationWithNewVersion(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+	keyConfig := &config.Config.ContentKeyEncryption
+	defer func() { *keyConfig = config.ContentKeyEncryption{} }()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte("k"), 32)
+	if err = idx.Add(Content{ID: "a", EncryptionKey: key, Location: "a.epub", Length: 10, Sha256: "aaaa"}); err != nil {
+		t.Fatal(err)
+	}
+	*keyConfig = config.ContentKeyEncryption{MasterKey: "000102030405060708090a0b0c0d0e0f"}
+	idx, err = Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new version is added between the listing and the update of a rotation
+	stale, err := idx.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := stale
+	c.Location, c.Length, c.Sha256 = "a-v2.epub", 12, "bbbb"
+	if _, err = idx.AddVersion(c); err != nil {
+		t.Fatal(err)
+	}
+	masterKeys, err := LoadMasterKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := idx.(dbIndex).rewrapKeys([]Content{stale}, masterKeys)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 rewrapped key, got %d (%v)", n, err)
+	}
+	if c, err = idx.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 2 || c.Location != "a-v2.epub" || c.Length != 12 || c.Sha256 != "bbbb" {
+		t.Errorf("The new version should be preserved, got %+v", c)
+	}
+	if c.KeyVersion != 1 || !bytes.Equal(c.EncryptionKey, key) {
+		t.Errorf("Expected %x with version 1, got %x with version %d", key, c.EncryptionKey, c.KeyVersion)
+	}
+
+	// a row rewrapped in the meantime is not updated again
+	if n, err = idx.(dbIndex).rewrapKeys([]Content{stale}, masterKeys); err != nil || n != 0 {
+		t.Errorf("Expected no rewrapped key, got %d (%v)", n, err)
+	}
+}
+
+func TestTenantIndex(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA, err := idx.Tenant("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantB, err := idx.Tenant("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultTenant, err := idx.Tenant("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tenantA.Add(Content{ID: "a1", EncryptionKey: []byte("1234"), Location: "a1.epub"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = defaultTenant.Add(Content{ID: "d1", EncryptionKey: []byte("1234"), Location: "d1.epub"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tenantA.Get("a1"); err != nil {
+		t.Error(err)
+	}
+	if _, err = tenantB.Get("a1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = defaultTenant.Get("a1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	// content ids are unique across tenants
+	if err = tenantB.Add(Content{ID: "a1", EncryptionKey: []byte("5678"), Location: "b.epub"}); err != ErrIDConflict {
+		t.Errorf("Expected ErrIDConflict, got %v", err)
+	}
+	if tenant, err := idx.TenantOf("a1"); err != nil || tenant != "a" {
+		t.Errorf("Expected tenant a, got %s (%v)", tenant, err)
+	}
+	if _, err = tenantB.TenantOf("a1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	// an update by another tenant has no effect
+	tenantB.Update(Content{ID: "a1", EncryptionKey: []byte("1234"), Location: "b.epub"})
+	if c, _ := tenantA.Get("a1"); c.Location != "a1.epub" {
+		t.Errorf("Unexpected location %s", c.Location)
+	}
+
+	count := func(i Index) int {
+		n := 0
+		fn := i.List()
+		for _, err := fn(); err == nil; _, err = fn() {
+			n++
+		}
+		return n
+	}
+	if count(tenantA) != 1 || count(tenantB) != 0 || count(defaultTenant) != 1 || count(idx) != 2 {
+		t.Errorf("Unexpected lists: %d, %d, %d, %d", count(tenantA), count(tenantB), count(defaultTenant), count(idx))
+	}
+	// a tenant cannot delete the contents of another tenant
+	if err = tenantB.Delete("a1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err = tenantA.Delete("a1"); err != nil {
+		t.Error(err)
+	}
+	if _, err = tenantA.Get("a1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if count(idx) != 1 {
+		t.Errorf("Expected 1 content, got %d", count(idx))
+	}
+}
+
+func TestFindContents(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []Content{
+		{ID: "1", Location: "moby.epub", Type: "application/epub+zip", Metadata: Metadata{Title: "Moby-Dick", Authors: []string{"Herman Melville"}, Language: "en", Publisher: "Harper & Brothers"}},
+		{ID: "2", Location: "bartleby.epub", Type: "application/epub+zip", Metadata: Metadata{Title: "Bartleby", Authors: []string{"Herman Melville"}, Language: "en"}},
+		{ID: "3", Location: "miserables.epub", Type: "application/epub+zip", Metadata: Metadata{Title: "Les Misérables", Authors: []string{"Victor Hugo"}, Language: "fr", Identifier: "urn:isbn:100%"}},
+		{ID: "4", Location: "sample.lcpdf", Type: "application/pdf+lcp"},
+	}
+	for _, c := range contents {
+		c.EncryptionKey = []byte("1234")
+		if err = idx.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(f Filter, page, pageNum int) []string {
+		var ids []string
+		fn := idx.Find(f, page, pageNum)
+		for c, err := fn(); err != ErrNotFound; c, err = fn() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	for _, test := range []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{}, []string{"4", "2", "3", "1"}},
+		{Filter{Search: "melville"}, []string{"2", "1"}},
+		{Filter{Search: "harper"}, []string{"1"}},
+		{Filter{Author: "hugo"}, []string{"3"}},
+		{Filter{Title: "moby", Language: "EN"}, []string{"1"}},
+		{Filter{Language: "fr"}, []string{"3"}},
+		{Filter{Type: "application/pdf+lcp"}, []string{"4"}},
+		{Filter{Identifier: "100%"}, []string{"3"}},
+		{Filter{Search: "%"}, []string{"3"}},
+		{Filter{Search: "dickens"}, nil},
+	} {
+		if ids := find(test.filter, 0, 0); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.filter, test.expected, ids)
+		}
+	}
+	if ids := find(Filter{}, 3, 1); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Errorf("Expected [1], got %v", ids)
+	}
+
+	// metadata are read back
+	c, err := idx.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Metadata, contents[0].Metadata) {
+		t.Errorf("Expected %+v, got %+v", contents[0].Metadata, c.Metadata)
+	}
+}
+
+func TestContentVersions(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA, err := idx.Tenant("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Content{ID: "v1", EncryptionKey: []byte("1234"), Location: "v1.epub", Length: 10, Sha256: "aaaa", Type: "application/epub+zip"}
+	if err = idx.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = idx.Get("v1"); err != nil || c.Version != 1 {
+		t.Fatalf("Expected version 1, got %d (%v)", c.Version, err)
+	}
+
+	c.Location, c.Length, c.Sha256, c.Title = "v1-fixed.epub", 12, "bbbb", "Fixed"
+	version, err := idx.AddVersion(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Expected version 2, got %d", version)
+	}
+	current, err := idx.Get("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Version != 2 || current.Sha256 != "bbbb" || current.Length != 12 || current.Title != "Fixed" {
+		t.Errorf("Unexpected current version %+v", current)
+	}
+	if string(current.EncryptionKey) != "1234" {
+		t.Error("The content key should not be modified by a new version")
+	}
+
+	versions, err := idx.Versions("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	if versions[0].Version != 1 || versions[0].Sha256 != "aaaa" || versions[0].Location != "v1.epub" || versions[0].Archived == nil {
+		t.Errorf("Unexpected first version %+v", versions[0])
+	}
+	if versions[1].Version != 2 || versions[1].Sha256 != "bbbb" || versions[1].Archived != nil {
+		t.Errorf("Unexpected current version %+v", versions[1])
+	}
+
+	// versions are scoped by tenant
+	if _, err = tenantA.Versions("v1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = tenantA.AddVersion(c); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// a withdrawn content keeps its row, without its previous versions, and is not listed
+	if err = tenantA.Withdraw("v1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err = idx.Withdraw("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = idx.Get("v1"); err != nil || c.Withdrawn == nil || string(c.EncryptionKey) != "1234" {
+		t.Errorf("Expected a withdrawn content with its key, got %+v (%v)", c, err)
+	}
+	if versions, err = idx.Versions("v1"); err != nil || len(versions) != 1 {
+		t.Errorf("Expected the current version only, got %d (%v)", len(versions), err)
+	}
+	if _, err = idx.Find(Filter{}, 0, 0)(); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err = idx.Withdraw("v1"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// previous versions are deleted with the content
+	c.Version = 0
+	if _, err = idx.AddVersion(c); err != nil {
+		t.Fatal(err)
+	}
+	if err = idx.Delete("v1"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err = db.QueryRow("SELECT COUNT(*) FROM content_version").Scan(&n); err != nil || n != 0 {
+		t.Errorf("Expected no version left, got %d (%v)", n, err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "lcp-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewFileSystem(dir, "http://localhost/files")
+
+	add := func(id string, indexed, stored []byte) {
+		sum := sha256.Sum256(indexed)
+		c := Content{ID: id, EncryptionKey: []byte("1234"), Location: id + ".epub", Length: int64(len(indexed)), Sha256: hex.EncodeToString(sum[:])}
+		if err := idx.Add(c); err != nil {
+			t.Fatal(err)
+		}
+		if stored != nil {
+			if _, err := store.Add(id, bytes.NewReader(stored)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add("good", []byte("good content"), []byte("good content"))
+	add("missing", []byte("missing content"), nil)
+	add("truncated", []byte("truncated content"), []byte("truncated"))
+	add("corrupted", []byte("corrupted content"), []byte("CORRUPTED content"))
+	if _, err = store.Add("orphan", bytes.NewReader([]byte("orphan"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archived version of a content is stored under its own key
+	good, err := idx.Get("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Add(ArchiveKey("good", 1), bytes.NewReader([]byte("good content"))); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("good content v2"))
+	good.Length, good.Sha256 = 15, hex.EncodeToString(sum[:])
+	if _, err = idx.AddVersion(good); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Add("good", bytes.NewReader([]byte("good content v2"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the files of a withdrawn content are removed, it is not checked
+	add("withdrawn", []byte("withdrawn content"), nil)
+	if err = idx.Withdraw("withdrawn"); err != nil {
+		t.Fatal(err)
+	}
+	// the versions stored by the encryption tool outside the storage of the server are not checked
+	external := Content{ID: "external", EncryptionKey: []byte("1234"), Location: "https://cdn.example.com/external.epub", Sha256: "aaaa"}
+	if err = idx.Add(external); err != nil {
+		t.Fatal(err)
+	}
+	external.Location, external.Sha256 = "https://cdn.example.com/external-v2.epub", "bbbb"
+	if _, err = idx.AddVersion(external); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Audit(store, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 || report.External != 2 {
+		t.Errorf("Expected 5 checked items and 2 external versions, got %d and %d", report.Checked, report.External)
+	}
+	statuses := make(map[string]string)
+	for _, issue := range report.Issues {
+		statuses[issue.Key] = issue.Status
+	}
+	expected := map[string]string{"missing": AuditMissing, "truncated": AuditTruncated, "corrupted": AuditCorrupted}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("Expected issues %v, got %v", expected, statuses)
+	}
+	if !reflect.DeepEqual(report.Orphans, []string{"orphan"}) {
+		t.Errorf("Expected the orphan item, got %v", report.Orphans)
+	}
+
+	// the items of the contents of other tenants are orphans
+	tenantA, err := idx.Tenant("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err = Audit(store, tenantA); err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 0 || len(report.Orphans) != 5 {
+		t.Errorf("Expected 5 orphans, got %v", report.Orphans)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package index
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/crypto"
+)
+
+// KeyClear is the key version of a content key stored as-is.
+// Any other key version is the version of the master key used for wrapping (RFC 5649) the content key.
+const KeyClear = 0
+
+// ErrMasterKeyMissing is returned when a protected content key is read without the corresponding master key
+var ErrMasterKeyMissing = errors.New("a master key is required for reading protected content keys")
+
+// ErrBadMasterKey is returned when the master key is not a hex encoded AES key
+var ErrBadMasterKey = errors.New("the master key must be a hex encoded 128, 192 or 256 bit key")
+
+// MasterKeys holds the current master key and the previous ones, indexed by version
+type MasterKeys struct {
+	Version int
+	Keys    map[int][]byte
+}
+
+// Current returns the current master key, nil if no master key is configured
+func (m MasterKeys) Current() []byte {
+	return m.Keys[m.Version]
+}
+
+// LoadMasterKeys returns the master key-encryption keys set in the configuration,
+// either directly or via key files. The current key is version 1 by default.
+func LoadMasterKeys() (MasterKeys, error) {
+
+	keyConfig := config.Config.ContentKeyEncryption
+	keys := MasterKeys{Version: keyConfig.MasterKeyVersion, Keys: make(map[int][]byte)}
+	if keys.Version == 0 {
+		keys.Version = 1
+	}
+
+	current, err := readMasterKey(keyConfig.MasterKey, keyConfig.MasterKeyFile)
+	if err != nil {
+		return keys, err
+	}
+	if current != nil {
+		keys.Keys[keys.Version] = current
+	}
+	for _, previous := range keyConfig.PreviousMasterKeys {
+		if previous.Version <= KeyClear {
+			return keys, fmt.Errorf("invalid master key version %d", previous.Version)
+		}
+		if _, exists := keys.Keys[previous.Version]; exists {
+			return keys, fmt.Errorf("duplicate master key version %d", previous.Version)
+		}
+		key, err := readMasterKey(previous.Key, previous.KeyFile)
+		if err != nil {
+			return keys, err
+		}
+		if key == nil {
+			return keys, fmt.Errorf("missing master key version %d", previous.Version)
+		}
+		keys.Keys[previous.Version] = key
+	}
+	return keys, nil
+}
+
+// readMasterKey returns a master key set directly or via a key file
+func readMasterKey(hexKey, keyFile string) ([]byte, error) {
+
+	if hexKey == "" && keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		hexKey = string(b)
+	}
+	return decodeMasterKey(hexKey)
+}
+
+// decodeMasterKey decodes a hex encoded key and checks its length
+func decodeMasterKey(hexKey string) ([]byte, error) {
+
+	hexKey = strings.TrimSpace(hexKey)
+	if hexKey == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, ErrBadMasterKey
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, ErrBadMasterKey
+}
+
+// protectKey wraps the content key with the current master key, if a master key is set
+func (i dbIndex) protectKey(c *Content) error {
+
+	masterKey := i.masterKeys.Current()
+	if masterKey == nil {
+		c.KeyVersion = KeyClear
+		return nil
+	}
+	wrapped, err := crypto.KeyWrapWithPadding(masterKey, c.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	c.EncryptionKey = wrapped
+	c.KeyVersion = i.masterKeys.Version
+	return nil
+}
+
+// unprotectKey unwraps the content key read from the database, if it is protected
+func (i dbIndex) unprotectKey(c *Content) error {
+
+	if c.KeyVersion == KeyClear {
+		return nil
+	}
+	masterKey, ok := i.masterKeys.Keys[c.KeyVersion]
+	if !ok {
+		return ErrMasterKeyMissing
+	}
+	key, err := crypto.KeyUnwrapWithPadding(masterKey, c.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	c.EncryptionKey = key
+	return nil
+}
+
+// ProtectContentKeys wraps with the current master key every content key still stored in clear.
+// It returns the number of updated rows.
+func ProtectContentKeys(idx Index) (int, error) {
+
+	return rewrapContentKeys(idx, 0, nil, func(c Content, version int) bool {
+		return c.KeyVersion == KeyClear
+	})
+}
+
+// RotateContentKeys wraps with the current master key every content key protected by a previous master key
+// or stored in clear. Keys are updated by batches of batchSize rows (all at once if batchSize <= 0),
+// and progress is called after each batch with the number of updated rows.
+// As the key version is stored with each content key, an interrupted rotation is resumed by calling it again.
+func RotateContentKeys(idx Index, batchSize int, progress func(done, total int)) (int, error) {
+
+	return rewrapContentKeys(idx, batchSize, progress, func(c Content, version int) bool {
+		return c.KeyVersion != version
+	})
+}
+
+// rewrapContentKeys walks the index, then updates the selected rows with the current master key
+func rewrapContentKeys(idx Index, batchSize int, progress func(done, total int), selected func(Content, int) bool) (int, error) {
+
+	i, ok := idx.(dbIndex)
+	if !ok {
+		return 0, errors.New("content keys can only be rewrapped in a database index")
+	}
+	masterKeys, err := LoadMasterKeys()
+	if err != nil {
+		return 0, err
+	}
+	if masterKeys.Current() == nil {
+		return 0, ErrMasterKeyMissing
+	}
+
+	// list the rows first, as the list query keeps a connection busy
+	var contents []Content
+	fn := idx.List()
+	c, err := fn()
+	for ; err == nil; c, err = fn() {
+		if selected(c, masterKeys.Version) {
+			contents = append(contents, c)
+		}
+	}
+	if err != ErrNotFound {
+		return 0, err
+	}
+
+	if batchSize <= 0 {
+		batchSize = len(contents)
+	}
+	count := 0
+	for start := 0; start < len(contents); start += batchSize {
+		end := start + batchSize
+		if end > len(contents) {
+			end = len(contents)
+		}
+		n, err := i.rewrapKeys(contents[start:end], masterKeys)
+		if err != nil {
+			return count, err
+		}
+		count += n
+		if progress != nil {
+			progress(start+len(contents[start:end]), len(contents))
+		}
+	}
+	return count, nil
+}
+
+// rewrapKeys wraps the content keys of a batch of contents with the current master key, in a single transaction.
+// Only the key columns are updated, and only if the key version has not changed since the contents were read,
+// so that concurrent updates of the other columns (e.g. a new version of the content) are preserved.
+// It returns the number of updated rows.
+func (i dbIndex) rewrapKeys(contents []Content, masterKeys MasterKeys) (int, error) {
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, c := range contents {
+		wrapped, err := crypto.KeyWrapWithPadding(masterKeys.Current(), c.EncryptionKey)
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.Exec("UPDATE content SET encryption_key=?, key_version=? WHERE id=? AND key_version=?"+i.tenantCond("AND"),
+			i.tenantArgs(wrapped, masterKeys.Version, c.ID, c.KeyVersion)...)
+		if err != nil {
+			return 0, err
+		}
+		// the row was deleted or its key rewrapped in the meantime
+		if n, _ := result.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+	return count, tx.Commit()
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Version is a version of the encrypted file of a content.
+// Every version of a content is encrypted with the same content key.
+type Version struct {
+	ContentID           string `json:"content_id"`
+	Version             int    `json:"version"`
+	Location            string `json:"location"`
+	Length              int64  `json:"length"`
+	Sha256              string `json:"sha256"`
+	Type                string `json:"type"`
+	EncryptionAlgorithm string `json:"encryption_algorithm,omitempty"`
+	// date the version was replaced by a new one, nil for the current version
+	Archived *time.Time `json:"archived,omitempty"`
+}
+
+// ArchiveKey returns the storage key of an archived version of a content
+func ArchiveKey(contentID string, version int) string {
+	return fmt.Sprintf("%s.v%d", contentID, version)
+}
+
+// StorageKey returns the storage key of a version: the content id for the current version
+func (v Version) StorageKey() string {
+	if v.Archived == nil {
+		return v.ContentID
+	}
+	return ArchiveKey(v.ContentID, v.Version)
+}
+
+// currentVersion gets the current version of a visible content
+func (i dbIndex) currentVersion(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, id string) (Version, error) {
+	var v Version
+	row := q.QueryRow("SELECT id,version,location,length,sha256,type,encryption_algorithm FROM content WHERE id=?"+
+		i.tenantCond("AND"), i.tenantArgs(id)...)
+	err := row.Scan(&v.ContentID, &v.Version, &v.Location, &v.Length, &v.Sha256, &v.Type, &v.EncryptionAlgorithm)
+	if err == sql.ErrNoRows {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+// AddVersion replaces the encrypted file of a content by a new version:
+// the current file info is archived as a previous version, then the content is updated
+// with the file info and metadata of c and its version number is incremented.
+// The content key is not modified. It returns the new version number.
+func (i dbIndex) AddVersion(c Content) (int, error) {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	current, err := i.currentVersion(tx, c.ID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`INSERT INTO content_version (content_id,version,location,length,sha256,type,encryption_algorithm,archived)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		current.ContentID, current.Version, current.Location, current.Length, current.Sha256, current.Type,
+		current.EncryptionAlgorithm, time.Now().UTC().Truncate(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	version := current.Version + 1
+	_, err = tx.Exec(`UPDATE content SET location=?, length=?, sha256=?, type=?, encryption_algorithm=?,
+		title=?, authors=?, language=?, identifier=?, publisher=?, version=? WHERE id=?`,
+		c.Location, c.Length, c.Sha256, c.Type, c.EncryptionAlgorithm,
+		c.Title, storedAuthors(c), c.Language, c.Identifier, c.Publisher, version, c.ID)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// Versions returns the versions of a content, from the first one to the current one
+func (i dbIndex) Versions(id string) ([]Version, error) {
+	current, err := i.currentVersion(i.db, id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := i.db.Query(`SELECT content_id,version,location,length,sha256,type,encryption_algorithm,archived
+		FROM content_version WHERE content_id=? ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []Version
+	for rows.Next() {
+		var v Version
+		var archived time.Time
+		err = rows.Scan(&v.ContentID, &v.Version, &v.Location, &v.Length, &v.Sha256, &v.Type, &v.EncryptionAlgorithm, &archived)
+		if err != nil {
+			return nil, err
+		}
+		v.Archived = &archived
+		versions = append(versions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return append(versions, current), nil
+}
+
+const versionTableDef = "CREATE TABLE IF NOT EXISTS content_version (" +
+	"content_id varchar(255) NOT NULL," +
+	"version integer NOT NULL," +
+	"location text NOT NULL," +
+	"length bigint," +
+	"sha256 varchar(64)," +
+	"\"type\" varchar(255) NOT NULL default 'application/epub+zip'," +
+	"encryption_algorithm varchar(255) NOT NULL default ''," +
+	"archived datetime NOT NULL," +
+	"PRIMARY KEY (content_id, version))"
@@ -27,7 +27,8 @@ func showHelpAndExit() {
 	fmt.Println("[-filename]   optional, file name of the encrypted publication; if omitted, contentid is used")
 	fmt.Println("[-output]     optional, target folder of encrypted publications")
 	fmt.Println("[-temp]       optional, working folder for temporary files")
-	fmt.Println("[-contentkey]  optional, base64 encoded content key; if omitted a random content key is generated")
+	fmt.Println("[-contentkey]  optional, base64 encoded content key; if omitted, the key of an existing content is fetched from the License server, or a random content key is generated")
+	fmt.Println("[-mode]       optional, encryption mode of the publication resources, CBC (default) or GCM")
 	fmt.Println("[-lcpsv]      optional, http endpoint, notification of the License server")
 	fmt.Println("[-login]      login (License server) ")
 	fmt.Println("[-password]   password (License server)")
@@ -51,6 +52,7 @@ func main() {
 	var outputRepo = flag.String("output", "", "optional, target folder of encrypted publications")
 	var tempRepo = flag.String("temp", "", "optional, working folder for temporary files")
 	var contentkey = flag.String("contentkey", "", "optional, base64 encoded content key; if omitted a random content key is generated")
+	var mode = flag.String("mode", "", "optional, encryption mode of the publication resources, CBC (default) or GCM")
 	var lcpsv = flag.String("lcpsv", "", "optional, http endpoint, notification of the License server")
 	var username = flag.String("login", "", "login (License server)")
 	var password = flag.String("password", "", "password (License server)")
@@ -77,10 +79,19 @@ func main() {
 		exitWithError("Parameters", errors.New("incorrect parameters, storage must not contain a file name, for more information type 'lcpencrypt -help' "))
 	}
 
+	// a new version of an existing content is encrypted with the same content key
+	if *contentkey == "" && *contentid != "" && *lcpsv != "" {
+		key, err := encrypt.FetchContentKey(*contentid, *lcpsv, *username, *password)
+		if err != nil {
+			exitWithError("Fetch the content key from the LCP Server", err)
+		}
+		*contentkey = key
+	}
+
 	start := time.Now()
 
 	// encrypt the publication
-	pub, err := encrypt.ProcessEncryption(*contentid, *contentkey, *inputPath, *tempRepo, *outputRepo, *storageRepo, *storageURL, *storageFilename)
+	pub, err := encrypt.ProcessEncryption(*contentid, *contentkey, *inputPath, *tempRepo, *outputRepo, *storageRepo, *storageURL, *storageFilename, *mode)
 	if err != nil {
 		exitWithError("Process the encryption of a publication", err)
 	}
@@ -0,0 +1,241 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/problem"
+	"github.com/readium/readium-lcp-server/schema"
+)
+
+// MaxBulkLicenses is the maximum number of licenses generated by a single bulk request
+const MaxBulkLicenses = 10000
+
+// BulkLicenseRequest is an item of a bulk license generation request
+type BulkLicenseRequest struct {
+	ContentID string          `json:"content_id"`
+	Policy    string          `json:"policy,omitempty"`
+	Profile   string          `json:"profile,omitempty"`
+	License   license.License `json:"license"`
+}
+
+// BulkLicenseResult is the result of the generation of a license in a bulk request,
+// either a license or a problem
+type BulkLicenseResult struct {
+	Index     int              `json:"index"`
+	ContentID string           `json:"content_id"`
+	Status    int              `json:"status"`
+	License   *license.License `json:"license,omitempty"`
+	Problem   *problem.Problem `json:"problem,omitempty"`
+}
+
+// LsdNotification notifies the License Status Server of a new license, as part of a bulk notification
+type LsdNotification struct {
+	License            license.License `json:"license"`
+	PotentialRightsEnd *time.Time      `json:"potential_rights_end,omitempty"`
+}
+
+// LsdNotificationResult is the result of the creation of a license status, as part of a bulk notification
+type LsdNotificationResult struct {
+	ID      string           `json:"id"`
+	Status  int              `json:"status"`
+	Problem *problem.Problem `json:"problem,omitempty"`
+}
+
+// GenerateLicenses generates licenses in bulk, possibly for different contents.
+// The input body is a json array of BulkLicenseRequest; the output body is a json array of BulkLicenseResult,
+// so that an erroneous item does not fail the whole request.
+// Licenses are built and signed in parallel, then stored; the License Status Server is notified in bulk.
+func GenerateLicenses(w http.ResponseWriter, r *http.Request, s Server) {
+
+	var rawItems []json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&rawItems)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if len(rawItems) == 0 || len(rawItems) > MaxBulkLicenses {
+		problem.Error(w, r, problem.Problem{Detail: fmt.Sprintf("the number of licenses must be between 1 and %d", MaxBulkLicenses)}, http.StatusBadRequest)
+		return
+	}
+
+	// items are validated separately, so that an erroneous item does not fail the whole request
+	items := make([]BulkLicenseRequest, len(rawItems))
+	invalid := make([]error, len(rawItems))
+	for i, raw := range rawItems {
+		if invalid[i] = schema.BulkLicenseRequest.Validate(raw); invalid[i] == nil {
+			invalid[i] = json.Unmarshal(raw, &items[i])
+		}
+	}
+
+	results := make([]BulkLicenseResult, len(items))
+	potentialEnds := make([]*time.Time, len(items))
+
+	// build and sign the licenses in parallel
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if invalid[i] != nil {
+					results[i] = bulkProblem(i, items[i].ContentID, invalid[i], http.StatusBadRequest)
+					continue
+				}
+				results[i], potentialEnds[i] = generateBulkLicense(i, &items[i], s)
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// store the licenses
+	var notifications []LsdNotification
+	for i := range results {
+		if results[i].License == nil {
+			continue
+		}
+		if err = s.Licenses().Add(*results[i].License); err != nil {
+			results[i] = bulkProblem(i, results[i].ContentID, err, http.StatusInternalServerError)
+			continue
+		}
+		log.Println("New License:", results[i].License.ID, ". Content:", results[i].ContentID, "User:", results[i].License.User.ID)
+		notifications = append(notifications, LsdNotification{License: *results[i].License, PotentialRightsEnd: potentialEnds[i]})
+	}
+
+	w.Header().Add("Content-Type", api.ContentType_JSON)
+	w.WriteHeader(http.StatusOK)
+	// do not escape characters
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(results)
+
+	// notify the lsd server of the creation of the licenses.
+	// this is an asynchronous call.
+	if len(notifications) > 0 {
+		go notifyLsdServerBulk(notifications, s)
+	}
+}
+
+// generateBulkLicense checks and builds a license of a bulk request
+func generateBulkLicense(i int, item *BulkLicenseRequest, s Server) (BulkLicenseResult, *time.Time) {
+
+	lic := &item.License
+	if item.ContentID == "" {
+		return bulkProblem(i, item.ContentID, ErrMandatoryInfoMissing, http.StatusBadRequest), nil
+	}
+	// check mandatory information in the partial license
+	if err := checkGenerateLicenseInput(lic); err != nil {
+		return bulkProblem(i, item.ContentID, err, http.StatusBadRequest), nil
+	}
+	// init the license with an id and issue date
+	license.Initialize(item.ContentID, lic)
+	// set the rights from the license policy, if requested
+	potentialEnd, err := applyPolicy(item.Policy, lic)
+	if err != nil {
+		return bulkProblem(i, item.ContentID, err, http.StatusBadRequest), nil
+	}
+	// normalize the start and end date, UTC, no milliseconds
+	setRights(lic)
+
+	// the profile of the server is used by default
+	profile := s.Profile()
+	if item.Profile != "" {
+		if profile, err = license.GetProfile(item.Profile); err != nil {
+			return bulkProblem(i, item.ContentID, fmt.Errorf("%s: %s", err.Error(), item.Profile), http.StatusBadRequest), nil
+		}
+	}
+	// build the license
+	if err = buildLicense(lic, profile, s); err != nil {
+		status := http.StatusInternalServerError
+		if err == index.ErrNotFound {
+			status = http.StatusNotFound
+		} else if err == index.ErrWithdrawn {
+			status = http.StatusGone
+		}
+		return bulkProblem(i, item.ContentID, err, status), nil
+	}
+	return BulkLicenseResult{Index: i, ContentID: item.ContentID, Status: http.StatusCreated, License: lic}, potentialEnd
+}
+
+func bulkProblem(i int, contentID string, err error, status int) BulkLicenseResult {
+	p := &problem.Problem{Title: http.StatusText(status), Status: status, Detail: err.Error()}
+	if e, ok := err.(problem.InvalidParamsError); ok {
+		p.InvalidParams = e.InvalidParams()
+	}
+	return BulkLicenseResult{
+		Index:     i,
+		ContentID: contentID,
+		Status:    status,
+		Problem:   p,
+	}
+}
+
+// notifyLsdServerBulk informs the License Status Server of the creation of new licenses
+// and saves the result of each creation in the DB
+func notifyLsdServerBulk(notifications []LsdNotification, s Server) {
+
+	if config.Config.LsdServer.PublicBaseUrl == "" {
+		return
+	}
+	// the status of every license is updated with the same value if the request fails
+	setStatus := func(status int32) {
+		for _, n := range notifications {
+			_ = s.Licenses().UpdateLsdStatus(n.License.ID, status)
+		}
+	}
+
+	body, err := json.Marshal(notifications)
+	if err != nil {
+		setStatus(-1)
+		return
+	}
+	req, err := http.NewRequest("PUT", config.Config.LsdServer.PublicBaseUrl+"/licenses/bulk", bytes.NewReader(body))
+	if err != nil {
+		setStatus(-1)
+		return
+	}
+	// set credentials on lsd request
+	notifyAuth := config.Config.LsdNotifyAuth
+	if notifyAuth.Username != "" {
+		req.SetBasicAuth(notifyAuth.Username, notifyAuth.Password)
+	}
+	req.Header.Add("Content-Type", api.ContentType_JSON)
+
+	lsdClient := &http.Client{Timeout: time.Minute}
+	response, err := lsdClient.Do(req)
+	if err != nil {
+		log.Println("Error Notify LsdServer of new Licenses:" + err.Error())
+		setStatus(-1)
+		return
+	}
+	defer response.Body.Close()
+
+	var results []LsdNotificationResult
+	if response.StatusCode != http.StatusOK || json.NewDecoder(response.Body).Decode(&results) != nil {
+		log.Println("Error Notify LsdServer of new Licenses, status", response.StatusCode)
+		setStatus(int32(response.StatusCode))
+		return
+	}
+	for _, res := range results {
+		_ = s.Licenses().UpdateLsdStatus(res.ID, int32(res.Status))
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/readium/readium-lcp-server/index"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/problem"
+	"github.com/readium/readium-lcp-server/schema"
 	"github.com/readium/readium-lcp-server/storage"
 )
 
@@ -92,7 +94,12 @@ func copyInputToLicense(licIn *license.License, licOut *license.License) {
 	// copy optional user information
 	licOut.User.Email = licIn.User.Email
 	licOut.User.Name = licIn.User.Name
+	// extension user properties are stored, the stored ones stay encrypted
+	stored := licOut.User
 	licOut.User.Encrypted = licIn.User.Encrypted
+	licOut.User.Extensions = nil
+	licOut.User.MergeExtensions(stored)
+	licOut.User.MergeExtensions(licIn.User)
 	licOut.Links = licIn.Links
 }
 
@@ -113,14 +120,55 @@ func setRights(lic *license.License) {
 	}
 }
 
-// build a license, common to get and generate license, get and generate licensed publication
-func buildLicense(lic *license.License, s Server) error {
+// applyPolicy sets the rights of a new license from a named policy, typically the "policy" query parameter.
+// It returns the end of the potential rights defined by the policy, if any.
+func applyPolicy(name string, lic *license.License) (*time.Time, error) {
 
-	// set the LCP profile
-	license.SetLicenseProfile(lic)
+	if name == "" {
+		return nil, nil
+	}
+	potentialEnd, err := license.ApplyPolicy(lic, name)
+	if err == license.ErrUnknownPolicy {
+		return nil, fmt.Errorf("%s: %s", err.Error(), name)
+	}
+	return potentialEnd, err
+}
 
-	// force the algorithm to the one defined by the basic and 1.0 profiles
-	lic.Encryption.UserKey.Algorithm = "http://www.w3.org/2001/04/xmlenc#sha256"
+// licenseProfile returns the license profile requested by the "profile" query parameter,
+// or the profile of the server
+func licenseProfile(r *http.Request, s Server) (license.Profile, error) {
+
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		return s.Profile(), nil
+	}
+	p, err := license.GetProfile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err.Error(), name)
+	}
+	return p, nil
+}
+
+// storedProfile returns the license profile an existing license was generated with.
+// A license stored without a profile name is built with the profile requested or the profile of the server.
+// Requesting another profile than the stored one is an error, as the user key of the license would change.
+func storedProfile(r *http.Request, lic license.License, s Server) (license.Profile, error) {
+
+	if lic.ProfileName == "" {
+		return licenseProfile(r, s)
+	}
+	if name := r.URL.Query().Get("profile"); name != "" && name != lic.ProfileName {
+		return nil, fmt.Errorf("the license is built with the profile %s, not %s", lic.ProfileName, name)
+	}
+	p, err := license.GetProfile(lic.ProfileName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err.Error(), lic.ProfileName)
+	}
+	return p, nil
+}
+
+// build a license, common to get and generate license, get and generate licensed publication
+func buildLicense(lic *license.License, profile license.Profile, s Server) error {
 
 	// get content info from the db
 	content, err := s.Index().Get(lic.ContentID)
@@ -128,9 +176,27 @@ func buildLicense(lic *license.License, s Server) error {
 		log.Println("No content with id", lic.ContentID)
 		return err
 	}
+	// the licenses issued before the withdrawal of a content can still be built, not new ones
+	if content.Withdrawn != nil && lic.Issued.After(*content.Withdrawn) {
+		return index.ErrWithdrawn
+	}
+
+	// set the provider of a tenant
+	if provider := s.Provider(); provider != "" {
+		lic.Provider = provider
+	}
+
+	// set the LCP profile, and record its name to rebuild the license with the same profile
+	if lic.ProfileName, err = license.ProfileName(profile); err != nil {
+		return err
+	}
+	license.SetLicenseProfileWith(lic, profile)
+
+	// force the algorithm to the one defined by the basic and 1.0 profiles
+	lic.Encryption.UserKey.Algorithm = "http://www.w3.org/2001/04/xmlenc#sha256"
 
 	// set links
-	err = license.SetLicenseLinks(lic, content)
+	err = license.SetLicenseLinksWith(lic, content, s.DefaultLinks())
 	if err != nil {
 		return err
 	}
@@ -140,7 +206,7 @@ func buildLicense(lic *license.License, s Server) error {
 		return err
 	}
 	// sign the license
-	err = license.SignLicense(lic, s.Certificate())
+	err = license.SignLicense(lic, s.Signers())
 	if err != nil {
 		return err
 	}
@@ -151,7 +217,9 @@ func buildLicense(lic *license.License, s Server) error {
 func copyZipFiles(out *zip.Writer, in *zip.Reader) error {
 
 	for _, file := range in.File {
-		newFile, err := out.CreateHeader(&file.FileHeader)
+		// the writer modifies the header, which must be kept intact for reading the file
+		header := file.FileHeader
+		newFile, err := out.CreateHeader(&header)
 		if err != nil {
 			return err
 		}
@@ -183,40 +251,41 @@ func isWebPub(in *zip.Reader) bool {
 	return false
 }
 
-// buildLicensedPublication builds a licensed publication, common to get and generate licensed publication
-func buildLicensedPublication(lic *license.License, s Server) (buf bytes.Buffer, err error) {
+// openPublication opens the encrypted publication of a content as a zip archive, without reading it in memory:
+// the zip archive is read via ranged reads of the storage item. The returned function releases the publication.
+func openPublication(contentID string, s Server) (*zip.Reader, func(), error) {
 
-	// get content info from the bd
-	item, err := s.Store().Get(lic.ContentID)
+	item, err := s.Store().Get(contentID)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
-	// read the content into a buffer
-	contents, err := item.Contents()
-	if err != nil {
-		return buf, err
-	}
-	b, err := ioutil.ReadAll(contents)
-	if err != nil {
-		return buf, err
+	if item.Size() < 0 {
+		return nil, nil, errors.New("the size of the encrypted publication is unknown")
 	}
-	// create a zip reader
-	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	reader := storage.NewItemReader(item)
+	zr, err := zip.NewReader(reader, item.Size())
 	if err != nil {
-		return buf, err
+		reader.Close()
+		return nil, nil, err
 	}
+	return zr, func() { reader.Close() }, nil
+}
+
+// writeLicensedPublication writes a licensed publication, i.e. the encrypted publication with the license injected,
+// common to get and generate licensed publication
+func writeLicensedPublication(w io.Writer, zr *zip.Reader, lic *license.License) error {
 
-	zipWriter := zip.NewWriter(&buf)
-	err = copyZipFiles(zipWriter, zr)
+	zipWriter := zip.NewWriter(w)
+	err := copyZipFiles(zipWriter, zr)
 	if err != nil {
-		return buf, err
+		return err
 	}
 
 	// Encode the license to JSON, remove the trailing newline
 	// write the buffer in the zip
 	licenseBytes, err := json.Marshal(lic)
 	if err != nil {
-		return buf, err
+		return err
 	}
 
 	licenseBytes = bytes.TrimRight(licenseBytes, "\n")
@@ -228,15 +297,56 @@ func buildLicensedPublication(lic *license.License, s Server) (buf bytes.Buffer,
 
 	licenseWriter, err := zipWriter.Create(location)
 	if err != nil {
-		return buf, err
+		return err
 	}
 
 	_, err = licenseWriter.Write(licenseBytes)
 	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// serveLicensedPublication streams a licensed publication to the caller,
+// common to get and generate licensed publication
+func serveLicensedPublication(w http.ResponseWriter, r *http.Request, lic *license.License, s Server) {
+
+	// open the encrypted publication
+	zr, release, err := openPublication(lic.ContentID, s)
+	if err == storage.ErrNotFound {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: lic.ContentID}, http.StatusNotFound)
+		return
+	} else if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: lic.ContentID}, http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	// get the content location to fill an http header
+	// FIXME: redundant as the content location has been set in a link (publication)
+	content, err := s.Index().Get(lic.ContentID)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: lic.ContentID}, http.StatusInternalServerError)
 		return
 	}
+	contentType := content.Type
+	if contentType == "" {
+		contentType = epub.ContentType_EPUB
+	}
 
-	return buf, zipWriter.Close()
+	// set HTTP headers
+	w.Header().Add("Content-Type", contentType)
+	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, content.Location))
+	// FIXME: check the use of X-Lcp-License by the caller (frontend?)
+	w.Header().Add("X-Lcp-License", lic.ID)
+	// must come *after* w.Header().Add()/Set(), but before w.Write()
+	w.WriteHeader(http.StatusCreated)
+	// stream the licensed publication to the caller;
+	// the response has started, an error can only be logged
+	if err = writeLicensedPublication(w, zr, lic); err != nil {
+		log.Println("Error writing the licensed publication of license", lic.ID, ":", err.Error())
+	}
 }
 
 // GetLicense returns an existing license,
@@ -285,8 +395,8 @@ func GetLicense(w http.ResponseWriter, r *http.Request, s Server) {
 			enc.Encode(licOut)
 			return
 		}
-		// unknown error
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		// erroneous input body
+		problem.BadRequest(w, r, err)
 		return
 	}
 
@@ -300,7 +410,12 @@ func GetLicense(w http.ResponseWriter, r *http.Request, s Server) {
 	// copy useful data from licIn to LicOut
 	copyInputToLicense(&licIn, &licOut)
 	// build the license
-	err = buildLicense(&licOut, s)
+	profile, err := storedProfile(r, licOut, s)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	err = buildLicense(&licOut, profile, s)
 	if err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
@@ -332,7 +447,7 @@ func GenerateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 	var lic license.License
 	err := DecodeJSONLicense(r, &lic)
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		problem.BadRequest(w, r, err)
 		return
 	}
 	// check mandatory information in the input body
@@ -344,12 +459,27 @@ func GenerateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 	// init the license with an id and issue date
 	license.Initialize(contentID, &lic)
 
+	// set the rights from the license policy, if requested
+	potentialEnd, err := applyPolicy(r.URL.Query().Get("policy"), &lic)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
 	// normalize the start and end date, UTC, no milliseconds
 	setRights(&lic)
 
 	// build the license
-	err = buildLicense(&lic, s)
+	profile, err := licenseProfile(r, s)
 	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	err = buildLicense(&lic, profile, s)
+	if err == index.ErrWithdrawn {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusGone)
+		return
+	} else if err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
@@ -376,7 +506,7 @@ func GenerateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 
 	// notify the lsd server of the creation of the license.
 	// this is an asynchronous call.
-	go notifyLsdServer(lic, s)
+	go notifyLsdServer(lic, potentialEnd, s)
 }
 
 // GetLicensedPublication returns a licensed publication
@@ -393,7 +523,7 @@ func GetLicensedPublication(w http.ResponseWriter, r *http.Request, s Server) {
 	var licIn license.License
 	err := DecodeJSONLicense(r, &licIn)
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		problem.BadRequest(w, r, err)
 		return
 	}
 	// check mandatory information in the input body
@@ -415,38 +545,18 @@ func GetLicensedPublication(w http.ResponseWriter, r *http.Request, s Server) {
 	// copy useful data from licIn to LicOut
 	copyInputToLicense(&licIn, &licOut)
 	// build the license
-	err = buildLicense(&licOut, s)
+	profile, err := storedProfile(r, licOut, s)
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
-		return
-	}
-	// build a licensed publication
-	buf, err := buildLicensedPublication(&licOut, s)
-	if err == storage.ErrNotFound {
-		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: licOut.ContentID}, http.StatusNotFound)
-		return
-	} else if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: licOut.ContentID}, http.StatusInternalServerError)
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 		return
 	}
-	// get the content location to fill an http header
-	// FIXME: redundant as the content location has been set in a link (publication)
-	content, err1 := s.Index().Get(licOut.ContentID)
-	if err1 != nil {
-		problem.Error(w, r, problem.Problem{Detail: err1.Error(), Instance: licOut.ContentID}, http.StatusInternalServerError)
+	err = buildLicense(&licOut, profile, s)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
-	location := content.Location
-
-	// set HTTP headers
-	w.Header().Add("Content-Type", epub.ContentType_EPUB)
-	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, location))
-	// FIXME: check the use of X-Lcp-License by the caller (frontend?)
-	w.Header().Add("X-Lcp-License", licOut.ID)
-	// must come *after* w.Header().Add()/Set(), but before w.Write()
-	w.WriteHeader(http.StatusCreated)
-	// return the full licensed publication to the caller
-	io.Copy(w, &buf)
+	// stream a licensed publication
+	serveLicensedPublication(w, r, &licOut, s)
 }
 
 // GenerateLicensedPublication generates and returns a licensed publication
@@ -463,7 +573,7 @@ func GenerateLicensedPublication(w http.ResponseWriter, r *http.Request, s Serve
 	var lic license.License
 	err := DecodeJSONLicense(r, &lic)
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		problem.BadRequest(w, r, err)
 		return
 	}
 	// check mandatory information in the input body
@@ -474,12 +584,26 @@ func GenerateLicensedPublication(w http.ResponseWriter, r *http.Request, s Serve
 	}
 	// init the license with an id and issue date
 	license.Initialize(contentID, &lic)
+	// set the rights from the license policy, if requested
+	potentialEnd, err := applyPolicy(r.URL.Query().Get("policy"), &lic)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
 	// normalize the start and end date, UTC, no milliseconds
 	setRights(&lic)
 
 	// build the license
-	err = buildLicense(&lic, s)
+	profile, err := licenseProfile(r, s)
 	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	err = buildLicense(&lic, profile, s)
+	if err == index.ErrWithdrawn {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusGone)
+		return
+	} else if err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
@@ -491,36 +615,10 @@ func GenerateLicensedPublication(w http.ResponseWriter, r *http.Request, s Serve
 	}
 
 	// notify the lsd server of the creation of the license
-	go notifyLsdServer(lic, s)
+	go notifyLsdServer(lic, potentialEnd, s)
 
-	// build a licenced publication
-	buf, err := buildLicensedPublication(&lic, s)
-	if err == storage.ErrNotFound {
-		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: lic.ContentID}, http.StatusNotFound)
-		return
-	} else if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: lic.ContentID}, http.StatusInternalServerError)
-		return
-	}
-
-	// get the content location to fill an http header
-	// FIXME: redundant as the content location has been set in a link (publication)
-	content, err1 := s.Index().Get(lic.ContentID)
-	if err1 != nil {
-		problem.Error(w, r, problem.Problem{Detail: err1.Error(), Instance: lic.ContentID}, http.StatusInternalServerError)
-		return
-	}
-	location := content.Location
-
-	// set HTTP headers
-	w.Header().Add("Content-Type", epub.ContentType_EPUB)
-	w.Header().Add("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, location))
-	// FIXME: check the use of X-Lcp-License by the caller (frontend?)
-	w.Header().Add("X-Lcp-License", lic.ID)
-	// must come *after* w.Header().Add()/Set(), but before w.Write()
-	w.WriteHeader(http.StatusCreated)
-	// return the full licensed publication to the caller
-	io.Copy(w, &buf)
+	// stream a licensed publication
+	serveLicensedPublication(w, r, &lic, s)
 }
 
 // UpdateLicense updates an existing license.
@@ -530,6 +628,7 @@ func GenerateLicensedPublication(w http.ResponseWriter, r *http.Request, s Serve
 // return: an http status code (200, 400 or 404)
 // Usually called from the License Status Server after a renew, return or cancel/revoke action
 // -> updates the end date.
+// The optional origin query parameter (lsd_renew, lsd_return, lsd_revoke) is recorded in the license history.
 func UpdateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 
 	vars := mux.Vars(r)
@@ -541,7 +640,7 @@ func UpdateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 	var licIn license.License
 	err := DecodeJSONLicense(r, &licIn)
 	if err != nil { // no or incorrect (json) partial license found in the body
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		problem.BadRequest(w, r, err)
 		return
 	}
 	// initialize the license from the info stored in the db.
@@ -560,7 +659,12 @@ func UpdateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 		log.Println("new user id: ", licIn.User.ID)
 		licOut.User.ID = licIn.User.ID
 	}
-	if licIn.Provider != "" {
+	if licIn.User.Extensions != nil {
+		log.Println("new user extensions")
+		licOut.User.MergeExtensions(licIn.User)
+	}
+	// the provider of a tenant cannot be modified
+	if licIn.Provider != "" && s.Provider() == "" {
 		log.Println("new provider: ", licIn.Provider)
 		licOut.Provider = licIn.Provider
 	}
@@ -585,13 +689,50 @@ func UpdateLicense(w http.ResponseWriter, r *http.Request, s Server) {
 		licOut.Rights.End = licIn.Rights.End
 	}
 	// update the license in the database
-	err = s.Licenses().Update(licOut)
+	origin := r.URL.Query().Get("origin")
+	if origin == "" {
+		origin = license.OriginPatch
+	} else if !license.IsOrigin(origin) {
+		problem.Error(w, r, problem.Problem{Detail: "unknown origin " + origin}, http.StatusBadRequest)
+		return
+	}
+	updated := time.Now().UTC().Truncate(time.Second)
+	licOut.Updated = &updated
+	err = s.Licenses().Update(licOut, licenseChange(r, origin))
 	if err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 }
 
+// GetLicenseHistory returns the changes of a license, in chronological order
+// parameters:
+// 		{license_id} in the calling URL
+// return: a json array of license history entries, or an http status code (404, 500)
+func GetLicenseHistory(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	licenseID := vars["license_id"]
+
+	entries, err := s.Licenses().History(licenseID)
+	if err == license.ErrNotFound {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusNotFound)
+		return
+	} else if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", api.ContentType_JSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// licenseChange identifies the authenticated caller of a license change
+func licenseChange(r *http.Request, origin string) license.Change {
+	caller, _, _ := r.BasicAuth()
+	return license.Change{Caller: caller, Origin: origin}
+}
+
 // ListLicenses returns a JSON struct with information about the existing licenses
 // parameters:
 // 	page: page number
@@ -723,29 +864,32 @@ func ListLicensesForContent(w http.ResponseWriter, r *http.Request, s Server) {
 
 }
 
-// DecodeJSONLicense decodes a license formatted in json and returns a license object
+// DecodeJSONLicense decodes a license formatted in json and returns a license object.
+// The license is validated against the partial license schema; io.EOF is returned if the body is empty.
 func DecodeJSONLicense(r *http.Request, lic *license.License) error {
 
-	var dec *json.Decoder
-
+	var body []byte
+	var err error
 	if ctype := r.Header["Content-Type"]; len(ctype) > 0 && ctype[0] == api.ContentType_FORM_URL_ENCODED {
-		buf := bytes.NewBufferString(r.PostFormValue("data"))
-		dec = json.NewDecoder(buf)
-	} else {
-		dec = json.NewDecoder(r.Body)
+		body = []byte(r.PostFormValue("data"))
+	} else if body, err = ioutil.ReadAll(r.Body); err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return io.EOF
 	}
 
-	err := dec.Decode(&lic)
-
-	if err != nil && err.Error() != "EOF" {
-		log.Print("Decode license: invalid json structure")
+	if err = schema.PartialLicense.Validate(body); err != nil {
+		log.Print("Decode license: " + err.Error())
+		return err
 	}
-	return err
+	return json.Unmarshal(body, lic)
 }
 
 // notifyLsdServer informs the License Status Server of the creation of a new license
-// and saves the result of the http request in the DB (using *Store)
-func notifyLsdServer(l license.License, s Server) {
+// and saves the result of the http request in the DB (using *Store).
+// The end of the potential rights is optional.
+func notifyLsdServer(l license.License, potentialEnd *time.Time, s Server) {
 
 	if config.Config.LsdServer.PublicBaseUrl != "" {
 		var lsdClient = &http.Client{
@@ -757,7 +901,11 @@ func notifyLsdServer(l license.License, s Server) {
 			_ = json.NewEncoder(pw).Encode(l)
 			pw.Close() // signal end writing
 		}()
-		req, err := http.NewRequest("PUT", config.Config.LsdServer.PublicBaseUrl+"/licenses", pr)
+		notifyURL := config.Config.LsdServer.PublicBaseUrl + "/licenses"
+		if potentialEnd != nil {
+			notifyURL += "?potential_rights_end=" + url.QueryEscape(potentialEnd.UTC().Format(time.RFC3339))
+		}
+		req, err := http.NewRequest("PUT", notifyURL, pr)
 		if err != nil {
 			return
 		}
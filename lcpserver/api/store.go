@@ -5,23 +5,29 @@
 package apilcp
 
 import (
-	"crypto/tls"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
 	"github.com/readium/readium-lcp-server/index"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/pack"
 	"github.com/readium/readium-lcp-server/problem"
+	"github.com/readium/readium-lcp-server/sign"
 	"github.com/readium/readium-lcp-server/storage"
 )
 
@@ -30,8 +36,14 @@ type Server interface {
 	Store() storage.Store
 	Index() index.Index
 	Licenses() license.Store
-	Certificate() *tls.Certificate
+	Signers() sign.SignerProvider
 	Source() *pack.ManualSource
+	// default license links
+	DefaultLinks() map[string]string
+	// provider uri set in every license; if empty, the provider is set by the caller
+	Provider() string
+	// license profile used when none is requested
+	Profile() license.Profile
 }
 
 // LcpPublication is used for communication with the License Server
@@ -44,6 +56,10 @@ type LcpPublication struct {
 	Size        int64  `json:"protected-content-length"`
 	Checksum    string `json:"protected-content-sha256"`
 	ContentType string `json:"protected-content-type,omitempty"`
+	// algorithm used for encrypting the resources of the publication
+	EncryptionAlgorithm string `json:"protected-content-encryption-algorithm,omitempty"`
+	// descriptive metadata extracted from the publication manifest
+	Metadata *index.Metadata `json:"metadata,omitempty"`
 }
 
 const (
@@ -112,6 +128,8 @@ func StoreContent(w http.ResponseWriter, r *http.Request, s Server) {
 // and adds the corresponding decryption key to the database.
 // The content_id is taken from  the url.
 // The input file is then deleted.
+// If the content already exists and the encrypted file has changed, a new version of the content is created:
+// it must be encrypted with the same content key, the previous version is kept and the licenses of the content are updated.
 func AddContent(w http.ResponseWriter, r *http.Request, s Server) {
 
 	// parse the json payload
@@ -120,7 +138,8 @@ func AddContent(w http.ResponseWriter, r *http.Request, s Server) {
 	var publication LcpPublication
 	err := decoder.Decode(&publication)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
 	}
 	// get the content ID in the url
 	contentID := vars["content_id"]
@@ -129,72 +148,217 @@ func AddContent(w http.ResponseWriter, r *http.Request, s Server) {
 		return
 	}
 
+	// get the current version of the content, if the content id already exists
+	c, err := s.Index().Get(contentID)
+	if err != nil && err != index.ErrNotFound {
+		problem.Error(w, r, problem.Problem{Detail: "Index:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+	exists := err == nil
+	// the id of a withdrawn content is not reused, as its licenses are kept
+	if exists && c.Withdrawn != nil {
+		problem.Error(w, r, problem.Problem{Detail: index.ErrWithdrawn.Error(), Instance: contentID}, http.StatusGone)
+		return
+	}
+	// the licenses of the content must remain valid for every version
+	if exists && !bytes.Equal(c.EncryptionKey, publication.ContentKey) {
+		problem.Error(w, r, problem.Problem{Detail: "a new version of a content must be encrypted with the content key of the content", Instance: contentID}, http.StatusConflict)
+		return
+	}
+	newVersion := exists && c.Sha256 != publication.Checksum
+
+	// the file info and metadata of the content
+	c.EncryptionKey = publication.ContentKey
+	// the Location field contains either the file name (useful during download)
+	// or the storage URL of the publication, depending the storage mode.
+	if publication.StorageMode != Storage_none {
+		c.Location = publication.Output
+	} else {
+		c.Location = publication.FileName
+	}
+	c.Length = publication.Size
+	c.Sha256 = publication.Checksum
+	c.Type = publication.ContentType
+	c.EncryptionAlgorithm = publication.EncryptionAlgorithm
+	if publication.Metadata != nil {
+		c.Metadata = *publication.Metadata
+	}
+
+	// insert a row in the database if the content id does not already exist,
+	// before the file is stored, as the id may be used by another tenant
+	if !exists {
+		c.ID = contentID
+		err = s.Index().Add(c)
+		if err == index.ErrIDConflict {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusConflict)
+			return
+		} else if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// on error, the index and the storage are put back in their previous state:
+	// the row of a new content is removed, and the file of the current version of an existing content
+	// is restored, as the index is only switched to a new version once its file is stored
+	var stored, archived bool
+	rollback := func() {
+		if !exists {
+			s.Index().Delete(contentID)
+		}
+		if newVersion && stored {
+			if err := restoreContentFile(c, archived, s); err != nil {
+				log.Println("Error restoring the file of content", contentID, ":", err.Error())
+			}
+		}
+	}
+
 	// if the encrypted publication has not been stored yet
 	if publication.StorageMode == Storage_none {
 
 		// open the encrypted file, use its full path
 		file, err := getAndOpenFile(publication.Output)
 		if err != nil {
+			rollback()
 			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 			return
 		}
 		// the input file will be deleted when the function returns
 		defer cleanupTempFile(file)
 
+		// keep the file of the current version before it is replaced
+		if newVersion {
+			if archived, err = archiveContentFile(c, s); err != nil {
+				problem.Error(w, r, problem.Problem{Detail: "Storage:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
+				return
+			}
+		}
 		// add the file to the storage, named by contentID, without file extension
+		stored = true
 		_, err = s.Store().Add(contentID, file)
 		if err != nil {
+			rollback()
 			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 			return
 		}
 	}
 
-	// insert a row in the database if the content id does not already exist
-	// or update the database with a new content key and file location if the content id already exists
-	var c index.Content
-	c, err = s.Index().Get(contentID)
-	c.EncryptionKey = publication.ContentKey
-	// the Location field contains either the file name (useful during download)
-	// or the storage URL of the publication, depending the storage mode.
-	if publication.StorageMode != Storage_none {
-		c.Location = publication.Output
-	} else {
-		c.Location = publication.FileName
-	}
-	c.Length = publication.Size
-	c.Sha256 = publication.Checksum
-	c.Type = publication.ContentType
-
+	// update the database with a new version and file location if the content id already exists
 	code := http.StatusCreated
-	if err == index.ErrNotFound { //insert into database
-		c.ID = contentID
-		err = s.Index().Add(c)
-	} else { //update the encryption key for c.ID = publication.ContentID
+	switch {
+	case !exists:
+		// already inserted
+	case newVersion:
+		var version int
+		if version, err = s.Index().AddVersion(c); err == nil {
+			c.Version = version
+		}
+		code = http.StatusOK
+	default:
 		err = s.Index().Update(c)
 		code = http.StatusOK
 	}
 	if err != nil { //if db not updated
+		rollback()
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
 		return
 	}
 
+	// update the licenses of the content, so that reading systems download the new version
+	var updates []LicenseUpdate
+	if newVersion {
+		log.Println("New version", c.Version, "of content", contentID)
+		updates, err = updateContentLicenses(contentID, licenseChange(r, license.OriginContentVersion), s)
+		if err != nil {
+			detail := fmt.Sprintf("version %d is stored, but its licenses could not be updated: %s", c.Version, err.Error())
+			problem.Error(w, r, problem.Problem{Detail: detail, Instance: contentID}, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// set the response http code
 	w.WriteHeader(code)
+
+	// notify the lsd server of the update of the licenses.
+	// this is an asynchronous call.
+	if len(updates) > 0 {
+		go notifyLsdServerUpdates(updates, s)
+	}
 }
 
 // ListContents lists the content in the storage index
+// parameters (optional):
+//
+//	q: text searched in the title, authors, identifier and publisher
+//	title, author, publisher, identifier: text searched in the corresponding metadata
+//	language, type: language and media type of the publication
+//	page: page number, starting at 1
+//	per_page: number of items par page; every content is listed if neither page nor per_page is set
 func ListContents(w http.ResponseWriter, r *http.Request, s Server) {
 
-	fn := s.Index().List()
+	query := r.URL.Query()
+	filter := index.Filter{
+		Search:     query.Get("q"),
+		Title:      query.Get("title"),
+		Author:     query.Get("author"),
+		Publisher:  query.Get("publisher"),
+		Identifier: query.Get("identifier"),
+		Language:   query.Get("language"),
+		Type:       query.Get("type"),
+	}
+
+	var page, perPage int64
+	var err error
+	if query.Get("page") != "" || query.Get("per_page") != "" {
+		page, perPage = 1, 30
+		if query.Get("page") != "" {
+			page, err = strconv.ParseInt(query.Get("page"), 10, 32)
+			if err != nil || page < 1 {
+				problem.Error(w, r, problem.Problem{Detail: "page must be a positive integer"}, http.StatusBadRequest)
+				return
+			}
+		}
+		if query.Get("per_page") != "" {
+			perPage, err = strconv.ParseInt(query.Get("per_page"), 10, 32)
+			if err != nil || perPage < 1 {
+				problem.Error(w, r, problem.Problem{Detail: "per_page must be a positive integer"}, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	fn := s.Index().Find(filter, int(perPage), int(page)-1)
 	contents := make([]index.Content, 0)
 
-	for it, err := fn(); err == nil; it, err = fn() {
+	for it, err := fn(); err != index.ErrNotFound; it, err = fn() {
+		if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			return
+		}
 		contents = append(contents, it)
 	}
 
+	// links to the previous and next pages, keeping the filter
+	if perPage > 0 {
+		var links []string
+		pageLink := func(p int64, rel string) string {
+			query.Set("page", strconv.FormatInt(p, 10))
+			return "<" + r.URL.Path + "?" + query.Encode() + ">; rel=\"" + rel + "\"; title=\"" + rel + "\""
+		}
+		if int64(len(contents)) == perPage {
+			links = append(links, pageLink(page+1, "next"))
+		}
+		if page > 1 {
+			links = append(links, pageLink(page-1, "previous"))
+		}
+		if len(links) > 0 {
+			w.Header().Set("Link", strings.Join(links, ", "))
+		}
+	}
+
 	w.Header().Set("Content-Type", api.ContentType_JSON)
 	enc := json.NewEncoder(w)
-	err := enc.Encode(contents)
+	err = enc.Encode(contents)
 	if err != nil {
 		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
 		return
@@ -229,25 +393,37 @@ func GetContent(w http.ResponseWriter, r *http.Request, s Server) {
 		}
 		return
 	}
-	// opens the file
-	contentReadCloser, err := item.Contents()
-	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: "File:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
-		return
-	}
+	// returns the content of the file to the caller
+	serveItem(w, r, item, content.Location, content.Type, content.Length)
+}
+
+// serveItem returns an encrypted file from the storage to the caller.
+// If the size of the storage item is known, partial (Range) and conditional (If-None-Match, If-Modified-Since) requests are supported,
+// which allows resumable downloads.
+func serveItem(w http.ResponseWriter, r *http.Request, item storage.Item, filename, contentType string, length int64) {
 
-	defer contentReadCloser.Close()
-	if err != nil { //file probably not found
-		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusBadRequest)
+	// set headers
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Header().Set("Content-Type", contentType)
+
+	if item.Size() < 0 {
+		contents, err := item.Contents()
+		if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: "File:" + err.Error(), Instance: item.Key()}, http.StatusInternalServerError)
+			return
+		}
+		defer contents.Close()
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+		io.Copy(w, contents)
 		return
 	}
-	// set headers
-	w.Header().Set("Content-Disposition", "attachment; filename="+content.Location)
-	w.Header().Set("Content-Type", content.Type)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", content.Length))
 
-	// returns the content of the file to the caller
-	io.Copy(w, contentReadCloser)
+	if etag := item.ETag(); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	reader := storage.NewItemReader(item)
+	defer reader.Close()
+	http.ServeContent(w, r, filename, item.LastModified(), reader)
 }
 
 // getAndOpenFile opens a file from a path, or downloads then opens it if its location is a URL
@@ -311,3 +487,150 @@ func downloadFile(url string, targetFilePath string) error {
 
 	return nil
 }
+
+// Policies applied when a content to delete has active licenses
+const (
+	ContentDeletionRefuse = "refuse"
+	ContentDeletionRevoke = "revoke"
+)
+
+// DeleteContent withdraws a content: its encrypted files are removed from the storage,
+// and it is marked as withdrawn in the index.
+// parameters:
+//
+//	{content_id} in the calling URL
+//	active_licenses (optional query parameter): "refuse" or "revoke"; the content_deletion value of the lcp section by default
+//
+// return: an http status code (200, 400, 404, 409, 410, 500 or 502)
+// A license is active if its end date is not set or in the future. Depending on the policy,
+// the deletion is refused while active licenses exist, or these licenses are first revoked via the License Status Server;
+// if some revocations fail, the content is not withdrawn and the request can be retried.
+// The licenses of the content and their history are kept, so that the License Status Server can still
+// serve them; the content key is kept in the index for the same reason, but no new license can be generated.
+func DeleteContent(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	contentID := vars["content_id"]
+
+	policy := r.URL.Query().Get("active_licenses")
+	if policy == "" {
+		policy = config.Config.LcpServer.ContentDeletion
+	}
+	if policy == "" {
+		policy = ContentDeletionRefuse
+	}
+	if policy != ContentDeletionRefuse && policy != ContentDeletionRevoke {
+		problem.Error(w, r, problem.Problem{Detail: "unknown content deletion policy " + policy}, http.StatusBadRequest)
+		return
+	}
+
+	content, err := s.Index().Get(contentID)
+	if err == index.ErrNotFound {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusNotFound)
+		return
+	} else if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+	if content.Withdrawn != nil {
+		problem.Error(w, r, problem.Problem{Detail: index.ErrWithdrawn.Error(), Instance: contentID}, http.StatusGone)
+		return
+	}
+	// the versions of the content, for the removal of the archived files
+	versions, err := s.Index().Versions(contentID)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+
+	// list the active licenses of the content
+	var active []string
+	now := time.Now().UTC()
+	fn := s.Licenses().ListForContent(contentID)
+	for l, err := fn(); err != license.ErrNotFound; l, err = fn() {
+		if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		if l.Rights.End == nil || l.Rights.End.After(now) {
+			active = append(active, l.ID)
+		}
+	}
+	if len(active) > 0 {
+		if policy == ContentDeletionRefuse {
+			problem.Error(w, r, problem.Problem{Detail: fmt.Sprintf("the content has %d active licenses", len(active)), Instance: contentID}, http.StatusConflict)
+			return
+		}
+		// the License Status Server updates the end date of each revoked license,
+		// which is therefore no longer active if the deletion is retried
+		var failed []string
+		for _, id := range active {
+			if err := revokeLicense(id, "The publication has been withdrawn"); err != nil {
+				log.Println("Error revoking license", id, ":", err.Error())
+				failed = append(failed, id)
+			}
+		}
+		if len(failed) > 0 {
+			detail := fmt.Sprintf("%d of %d licenses could not be revoked: %s", len(failed), len(active), strings.Join(failed, ", "))
+			problem.Error(w, r, problem.Problem{Detail: detail, Instance: contentID}, http.StatusBadGateway)
+			return
+		}
+		log.Println("Revoked", len(active), "licenses of content", contentID)
+	}
+
+	err = s.Index().Withdraw(contentID)
+	if err != nil && err != index.ErrNotFound {
+		problem.Error(w, r, problem.Problem{Detail: "Index:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+	// the encrypted files may already be missing from the storage
+	for _, v := range versions {
+		key := v.StorageKey()
+		if _, err = s.Store().Get(key); err == nil {
+			err = s.Store().Remove(key)
+		}
+		if err != nil && err != storage.ErrNotFound {
+			problem.Error(w, r, problem.Problem{Detail: "Storage:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Println("Withdrew content", contentID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeLicense revokes a license via the License Status Server,
+// which updates the end date of the license in return
+func revokeLicense(licenseID string, message string) error {
+
+	if config.Config.LsdServer.PublicBaseUrl == "" {
+		return errors.New("no License Status Server configured")
+	}
+	body, err := json.Marshal(struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{Status: "revoked", Message: message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PATCH", config.Config.LsdServer.PublicBaseUrl+"/licenses/"+licenseID+"/status", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// set credentials on lsd request
+	notifyAuth := config.Config.LsdNotifyAuth
+	if notifyAuth.Username != "" {
+		req.SetBasicAuth(notifyAuth.Username, notifyAuth.Password)
+	}
+	req.Header.Add("Content-Type", api.ContentType_LSD_JSON)
+
+	lsdClient := &http.Client{Timeout: 30 * time.Second}
+	response, err := lsdClient.Do(req)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("the License Status Server returned the status %d", response.StatusCode)
+	}
+	return nil
+}
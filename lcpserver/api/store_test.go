@@ -0,0 +1,228 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/sign"
+	"github.com/readium/readium-lcp-server/storage"
+)
+
+// testServer is a Server backed by an in-memory database and a file system storage
+type testServer struct {
+	store   storage.Store
+	idx     index.Index
+	lst     license.Store
+	signers sign.SignerProvider
+}
+
+func (s *testServer) Store() storage.Store            { return s.store }
+func (s *testServer) Index() index.Index              { return s.idx }
+func (s *testServer) Licenses() license.Store         { return s.lst }
+func (s *testServer) Signers() sign.SignerProvider    { return s.signers }
+func (s *testServer) Source() *pack.ManualSource      { return nil }
+func (s *testServer) DefaultLinks() map[string]string { return nil }
+func (s *testServer) Provider() string                { return "" }
+func (s *testServer) Profile() license.Profile        { return license.DefaultProfile() }
+
+func newTestServer(t *testing.T) *testServer {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := index.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst, err := license.NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "lcpserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &testServer{store: storage.NewFileSystem(dir, ""), idx: idx, lst: lst}
+}
+
+// addContent indexes a content with a previous version, and stores the files of both versions
+func (s *testServer) addContent(t *testing.T, id string) {
+	c := index.Content{ID: id, EncryptionKey: bytes.Repeat([]byte{1}, 32), Location: id + ".epub", Sha256: "aaaa"}
+	if err := s.idx.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	c.Sha256 = "bbbb"
+	if _, err := s.idx.AddVersion(c); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{index.ArchiveKey(id, 1), id} {
+		if _, err := s.store.Add(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// addLicense stores a license of a content, active if its end date is not set or in the future
+func (s *testServer) addLicense(t *testing.T, contentID string, end *time.Time) string {
+	l := license.License{User: license.UserInfo{ID: "user"}, Rights: &license.UserRights{End: end}}
+	license.Initialize(contentID, &l)
+	if err := s.lst.Add(l); err != nil {
+		t.Fatal(err)
+	}
+	return l.ID
+}
+
+// newLsdServer returns a License Status Server which records the revoked licenses,
+// and fails to revoke the licenses of the failing set
+func newLsdServer(t *testing.T, failing map[string]bool) func() []string {
+	var mu sync.Mutex
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/licenses/"), "/status")
+		if r.Method != "PATCH" || failing[id] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		revoked = append(revoked, id)
+		mu.Unlock()
+	}))
+	config.Config.LsdServer.PublicBaseUrl = server.URL
+	t.Cleanup(func() {
+		server.Close()
+		config.Config.LsdServer.PublicBaseUrl = ""
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return revoked
+	}
+}
+
+func deleteContent(s Server, contentID string, policy string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("DELETE", "/contents/"+contentID+"?active_licenses="+policy, nil)
+	r = mux.SetURLVars(r, map[string]string{"content_id": contentID})
+	w := httptest.NewRecorder()
+	DeleteContent(w, r, s)
+	return w
+}
+
+// checkStored checks that the files of both versions of a content are stored, or not
+func checkStored(t *testing.T, s *testServer, id string, expected bool) {
+	t.Helper()
+	for _, key := range []string{index.ArchiveKey(id, 1), id} {
+		if _, err := s.store.Get(key); (err == nil) != expected {
+			t.Errorf("Unexpected storage of %s: %v", key, err)
+		}
+	}
+}
+
+func TestDeleteContentRefuse(t *testing.T) {
+	s := newTestServer(t)
+	revoked := newLsdServer(t, nil)
+	s.addContent(t, "c1")
+	s.addLicense(t, "c1", nil)
+
+	if w := deleteContent(s, "c1", ContentDeletionRefuse); w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+	if len(revoked()) != 0 {
+		t.Errorf("Expected no revoked license, got %v", revoked())
+	}
+	if c, err := s.idx.Get("c1"); err != nil || c.Withdrawn != nil {
+		t.Errorf("Expected the content to be kept, got %+v (%v)", c, err)
+	}
+	checkStored(t, s, "c1", true)
+
+	if w := deleteContent(s, "c1", "unknown"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if w := deleteContent(s, "unknown", ContentDeletionRefuse); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestDeleteContentRevoke(t *testing.T) {
+	s := newTestServer(t)
+	revoked := newLsdServer(t, nil)
+	s.addContent(t, "c1")
+	active := s.addLicense(t, "c1", nil)
+	past := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	expired := s.addLicense(t, "c1", &past)
+
+	if w := deleteContent(s, "c1", ContentDeletionRevoke); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	// only the active license is revoked
+	if ids := revoked(); len(ids) != 1 || ids[0] != active {
+		t.Errorf("Expected the revocation of %s, got %v", active, ids)
+	}
+	// the content is withdrawn, its files are removed, its licenses are kept
+	c, err := s.idx.Get("c1")
+	if err != nil || c.Withdrawn == nil {
+		t.Errorf("Expected a withdrawn content, got %+v (%v)", c, err)
+	}
+	checkStored(t, s, "c1", false)
+	for _, id := range []string{active, expired} {
+		if _, err = s.lst.Get(id); err != nil {
+			t.Errorf("Expected license %s to be kept, got %v", id, err)
+		}
+	}
+
+	// a withdrawn content cannot be deleted again, nor get new licenses
+	if w := deleteContent(s, "c1", ContentDeletionRevoke); w.Code != http.StatusGone {
+		t.Errorf("Expected status 410, got %d", w.Code)
+	}
+	l := license.License{Issued: c.Withdrawn.Add(time.Second), ContentID: "c1"}
+	if err = buildLicense(&l, s.Profile(), s); err != index.ErrWithdrawn {
+		t.Errorf("Expected ErrWithdrawn, got %v", err)
+	}
+}
+
+func TestDeleteContentPartialRevoke(t *testing.T) {
+	s := newTestServer(t)
+	s.addContent(t, "c1")
+	revokable := s.addLicense(t, "c1", nil)
+	failing := s.addLicense(t, "c1", nil)
+	revoked := newLsdServer(t, map[string]bool{failing: true})
+
+	w := deleteContent(s, "c1", ContentDeletionRevoke)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("Expected status 502, got %d", w.Code)
+	}
+	// every license is tried, the failed ones are reported
+	if ids := revoked(); len(ids) != 1 || ids[0] != revokable {
+		t.Errorf("Expected the revocation of %s, got %v", revokable, ids)
+	}
+	if !strings.Contains(w.Body.String(), failing) || strings.Contains(w.Body.String(), revokable) {
+		t.Errorf("Expected the failed license in the problem, got %s", w.Body.String())
+	}
+	// the content is kept, so that the deletion can be retried
+	if c, err := s.idx.Get("c1"); err != nil || c.Withdrawn != nil {
+		t.Errorf("Expected the content to be kept, got %+v (%v)", c, err)
+	}
+	checkStored(t, s, "c1", true)
+}
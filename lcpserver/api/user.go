@@ -0,0 +1,184 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/problem"
+)
+
+// RekeyResult is the result of the re-keying of a license after a passphrase change
+type RekeyResult struct {
+	ID        string           `json:"id"`
+	ContentID string           `json:"content_id"`
+	Updated   *time.Time       `json:"updated,omitempty"`
+	Problem   *problem.Problem `json:"problem,omitempty"`
+}
+
+// LicenseUpdate notifies the License Status Server of the update of a license
+type LicenseUpdate struct {
+	ID      string    `json:"id"`
+	Updated time.Time `json:"updated"`
+}
+
+// RekeyUserLicenses rebuilds every license of a user after a passphrase change.
+// parameters:
+//
+//	{user_id} in the calling URL
+//	partial license containing the new user hint and passphrase hash,
+//	plus the user information to encrypt in the licenses
+//
+// return: a json array of RekeyResult
+// For each license, the key check and encrypted user fields are recomputed and the license is signed again;
+// the update date is bumped, and the License Status Server is notified,
+// so that reading systems fetch the updated licenses on their next status check.
+func RekeyUserLicenses(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+
+	var licIn license.License
+	err := DecodeJSONLicense(r, &licIn)
+	if err != nil {
+		problem.BadRequest(w, r, err)
+		return
+	}
+	// check the new user hint and passphrase hash
+	err = checkGetLicenseInput(&licIn)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	// check the requested profile; each license is rebuilt with its own profile
+	if _, err = licenseProfile(r, s); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	// list the licenses of the user first, as the list query keeps a connection busy
+	var ids []string
+	fn := s.Licenses().ListForUser(userID)
+	for l, err := fn(); err != license.ErrNotFound; l, err = fn() {
+		if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, l.ID)
+	}
+	if len(ids) == 0 {
+		problem.Error(w, r, problem.Problem{Detail: "no license found for this user"}, http.StatusNotFound)
+		return
+	}
+
+	results := make([]RekeyResult, 0, len(ids))
+	var updates []LicenseUpdate
+	for _, id := range ids {
+		res := RekeyResult{ID: id}
+		licOut, err := s.Licenses().Get(id)
+		if err == nil {
+			res.ContentID = licOut.ContentID
+			var profile license.Profile
+			if profile, err = storedProfile(r, licOut, s); err == nil {
+				err = rekeyLicense(&licIn, &licOut, profile, licenseChange(r, license.OriginRekey), s)
+			}
+		}
+		if err != nil {
+			log.Println("Error re-keying license", id, ":", err.Error())
+			res.Problem = &problem.Problem{Title: http.StatusText(http.StatusInternalServerError), Status: http.StatusInternalServerError, Detail: err.Error()}
+		} else {
+			res.Updated = licOut.Updated
+			updates = append(updates, LicenseUpdate{ID: id, Updated: *licOut.Updated})
+		}
+		results = append(results, res)
+	}
+	log.Println("Re-keyed", len(updates), "licenses of user", userID)
+
+	w.Header().Add("Content-Type", api.ContentType_JSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+
+	// notify the lsd server of the update of the licenses.
+	// this is an asynchronous call.
+	if len(updates) > 0 {
+		go notifyLsdServerUpdates(updates, s)
+	}
+}
+
+// rekeyLicense rebuilds a license with a new user key, then stores its update date
+func rekeyLicense(licIn *license.License, licOut *license.License, profile license.Profile, c license.Change, s Server) error {
+
+	copyInputToLicense(licIn, licOut)
+	updated := time.Now().UTC().Truncate(time.Second)
+	licOut.Updated = &updated
+	// recompute the key check, encrypted user fields and signature
+	if err := buildLicense(licOut, profile, s); err != nil {
+		return err
+	}
+	return s.Licenses().Update(*licOut, c)
+}
+
+// notifyLsdServerUpdates informs the License Status Server of the update of licenses,
+// and saves the result of each update in the DB, so that the failed updates can be retried
+func notifyLsdServerUpdates(updates []LicenseUpdate, s Server) {
+
+	if config.Config.LsdServer.PublicBaseUrl == "" {
+		return
+	}
+	// the status of every license is updated with the same value if the request fails
+	setStatus := func(status int32) {
+		for _, u := range updates {
+			_ = s.Licenses().UpdateLsdStatus(u.ID, status)
+		}
+	}
+
+	body, err := json.Marshal(updates)
+	if err != nil {
+		setStatus(-1)
+		return
+	}
+	req, err := http.NewRequest("PUT", config.Config.LsdServer.PublicBaseUrl+"/licenses/updated", bytes.NewReader(body))
+	if err != nil {
+		setStatus(-1)
+		return
+	}
+	// set credentials on lsd request
+	notifyAuth := config.Config.LsdNotifyAuth
+	if notifyAuth.Username != "" {
+		req.SetBasicAuth(notifyAuth.Username, notifyAuth.Password)
+	}
+	req.Header.Add("Content-Type", api.ContentType_JSON)
+
+	lsdClient := &http.Client{Timeout: time.Minute}
+	response, err := lsdClient.Do(req)
+	if err != nil {
+		log.Println("Error Notify LsdServer of updated Licenses:" + err.Error())
+		setStatus(-1)
+		return
+	}
+	defer response.Body.Close()
+
+	var results []LsdNotificationResult
+	if response.StatusCode != http.StatusOK || json.NewDecoder(response.Body).Decode(&results) != nil {
+		log.Println("Error Notify LsdServer of updated Licenses, status", response.StatusCode)
+		setStatus(int32(response.StatusCode))
+		return
+	}
+	for _, res := range results {
+		if res.Status != http.StatusOK {
+			log.Println("Error Notify LsdServer of the update of license", res.ID, ", status", res.Status)
+		}
+		_ = s.Licenses().UpdateLsdStatus(res.ID, int32(res.Status))
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/sign"
+)
+
+// slowSigner records the update date of the signed licenses,
+// and signs after the next second, so that a date taken after the signature would differ
+type slowSigner struct {
+	signed []time.Time
+}
+
+func (s *slowSigner) Signer(date time.Time) (sign.Signer, error) { return s, nil }
+
+func (s *slowSigner) Sign(in interface{}) (sign.Signature, error) {
+	if l, ok := in.(*license.License); ok && l.Updated != nil {
+		s.signed = append(s.signed, *l.Updated)
+	}
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	return sign.Signature{}, nil
+}
+
+func TestRekeyUserLicenses(t *testing.T) {
+	s := newTestServer(t)
+	signer := &slowSigner{}
+	s.signers = signer
+	s.addContent(t, "c1")
+	id := s.addLicense(t, "c1", nil)
+
+	body := `{"encryption": {"user_key": {"text_hint": "new hint", "hex_value": "` + strings.Repeat("ab", 32) + `"}}}`
+	r := httptest.NewRequest("PUT", "/users/user/passphrase", strings.NewReader(body))
+	r = mux.SetURLVars(r, map[string]string{"user_id": "user"})
+	w := httptest.NewRecorder()
+	RekeyUserLicenses(w, r, s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var results []RekeyResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil || len(results) != 1 || results[0].Problem != nil {
+		t.Fatalf("Expected a re-keyed license, got %+v (%v)", results, err)
+	}
+
+	// the stored update date is the signed one
+	if len(signer.signed) != 1 {
+		t.Fatalf("Expected 1 signed license, got %d", len(signer.signed))
+	}
+	l, err := s.lst.Get(id)
+	if err != nil || l.Updated == nil || !l.Updated.Equal(signer.signed[0]) {
+		t.Errorf("Expected the update date %s, got %v (%v)", signer.signed[0], l.Updated, err)
+	}
+	if history, err := s.lst.History(id); err != nil || len(history) != 1 || !history[0].Updated.Equal(signer.signed[0]) {
+		t.Errorf("Expected a history entry at %s, got %+v (%v)", signer.signed[0], history, err)
+	}
+}
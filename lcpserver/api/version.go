@@ -0,0 +1,213 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/problem"
+	"github.com/readium/readium-lcp-server/storage"
+)
+
+// ContentKeyRequest is sent by the encryption tool for encrypting a new version of a content with the same key.
+// The public key is a DER encoded PKIX RSA public key, generated for this request only.
+type ContentKeyRequest struct {
+	PublicKey []byte `json:"public-key"`
+}
+
+// WrappedContentKey is the content key of a content, encrypted with the public key of the request (RSA-OAEP, SHA-256),
+// so that the clear key is never sent by the server
+type WrappedContentKey struct {
+	ContentID  string `json:"content-id"`
+	WrappedKey []byte `json:"wrapped-content-key"`
+}
+
+// archiveContentFile copies the encrypted file of the current version of a content
+// to the storage key of this version, before it is replaced by a new version.
+// Nothing is archived if the file is not in the storage of the server; it returns whether the file was archived.
+func archiveContentFile(c index.Content, s Server) (bool, error) {
+
+	return copyStoredFile(c.ID, index.ArchiveKey(c.ID, c.Version), s)
+}
+
+// restoreContentFile puts back the archived file of the current version of a content,
+// when its new version cannot be indexed. Without archived file, the file of the new version is removed.
+func restoreContentFile(c index.Content, archived bool, s Server) error {
+
+	if !archived {
+		err := s.Store().Remove(c.ID)
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	key := index.ArchiveKey(c.ID, c.Version)
+	if _, err := copyStoredFile(key, c.ID, s); err != nil {
+		return err
+	}
+	return s.Store().Remove(key)
+}
+
+// copyStoredFile copies a file of the storage to another key; it returns false if there is no file to copy
+func copyStoredFile(from, to string, s Server) (bool, error) {
+
+	item, err := s.Store().Get(from)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	contents, err := item.Contents()
+	if err != nil {
+		return false, err
+	}
+	defer contents.Close()
+	// the storage requires a seekable reader
+	_, f, err := writeRequestFileToTemp(contents)
+	defer cleanupTempFile(f)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.Store().Add(to, f)
+	return err == nil, err
+}
+
+// updateContentLicenses sets the update date of the licenses of a content after the creation of a new version,
+// so that reading systems fetch a fresh license, with the hash and length of the new version in its publication link.
+// The change is recorded in the history of each license.
+// It returns the updates to be notified to the License Status Server.
+func updateContentLicenses(contentID string, c license.Change, s Server) ([]LicenseUpdate, error) {
+
+	updated := time.Now().UTC().Truncate(time.Second)
+	ids, err := s.Licenses().UpdateForContent(contentID, updated, c)
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]LicenseUpdate, 0, len(ids))
+	for _, id := range ids {
+		updates = append(updates, LicenseUpdate{ID: id, Updated: updated})
+	}
+	return updates, nil
+}
+
+// WrapContentKey returns the content key of a content, encrypted with the RSA public key of the request,
+// so that a new version of the content can be encrypted with the same key
+func WrapContentKey(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	contentID := vars["content_id"]
+
+	var req ContentKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusBadRequest)
+		return
+	}
+	parsed, err := x509.ParsePKIXPublicKey(req.PublicKey)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusBadRequest)
+		return
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok || publicKey.N.BitLen() < 2048 {
+		problem.Error(w, r, problem.Problem{Detail: "the public key must be a RSA key of 2048 bits at least", Instance: contentID}, http.StatusBadRequest)
+		return
+	}
+
+	content, err := s.Index().Get(contentID)
+	if err != nil {
+		if err == index.ErrNotFound {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusNotFound)
+		} else {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		}
+		return
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, content.EncryptionKey, nil)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	json.NewEncoder(w).Encode(WrappedContentKey{ContentID: content.ID, WrappedKey: wrapped})
+}
+
+// ListContentVersions lists the versions of a content, from the first one to the current one
+func ListContentVersions(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	contentID := vars["content_id"]
+	versions, err := s.Index().Versions(contentID)
+	if err != nil {
+		if err == index.ErrNotFound {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusNotFound)
+		} else {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(versions)
+}
+
+// GetContentVersion returns the encrypted file of a version of a content.
+// If the file of the version is not in the storage of the server but its location is a url,
+// the caller is redirected to this url.
+func GetContentVersion(w http.ResponseWriter, r *http.Request, s Server) {
+
+	vars := mux.Vars(r)
+	contentID := vars["content_id"]
+	number, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "the version must be an integer", Instance: contentID}, http.StatusBadRequest)
+		return
+	}
+	versions, err := s.Index().Versions(contentID)
+	if err != nil {
+		if err == index.ErrNotFound {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusNotFound)
+		} else {
+			problem.Error(w, r, problem.Problem{Detail: err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		}
+		return
+	}
+	var version *index.Version
+	for i := range versions {
+		if versions[i].Version == number {
+			version = &versions[i]
+		}
+	}
+	if version == nil {
+		problem.Error(w, r, problem.Problem{Detail: "unknown version " + vars["version"], Instance: contentID}, http.StatusNotFound)
+		return
+	}
+
+	item, err := s.Store().Get(version.StorageKey())
+	if err == storage.ErrNotFound {
+		if ok, _ := isURL(version.Location); ok {
+			http.Redirect(w, r, version.Location, http.StatusFound)
+			return
+		}
+		problem.Error(w, r, problem.Problem{Detail: "Storage:" + err.Error(), Instance: contentID}, http.StatusNotFound)
+		return
+	} else if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: "Storage:" + err.Error(), Instance: contentID}, http.StatusInternalServerError)
+		return
+	}
+	serveItem(w, r, item, version.Location, version.Type, version.Length)
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilcp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/license"
+)
+
+// failingIndex is an index which fails to add versions
+type failingIndex struct {
+	index.Index
+}
+
+func (i failingIndex) AddVersion(c index.Content) (int, error) {
+	return 0, errors.New("index failure")
+}
+
+// putContent adds an encrypted file to the server, as the encryption tool does
+func putContent(t *testing.T, s Server, contentID string, contents string, checksum string) *httptest.ResponseRecorder {
+	t.Helper()
+	f, err := ioutil.TempFile("", "readium-lcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(contents)
+	f.Close()
+	publication := LcpPublication{ContentID: contentID, ContentKey: bytes.Repeat([]byte{1}, 32), StorageMode: Storage_none,
+		Output: f.Name(), FileName: contentID + ".epub", Size: int64(len(contents)), Checksum: checksum}
+	body, _ := json.Marshal(publication)
+	r := httptest.NewRequest("PUT", "/contents/"+contentID, bytes.NewReader(body))
+	r.SetBasicAuth("admin", "secret")
+	r = mux.SetURLVars(r, map[string]string{"content_id": contentID})
+	w := httptest.NewRecorder()
+	AddContent(w, r, s)
+	return w
+}
+
+// checkStoredFile checks the contents of a file of the storage
+func checkStoredFile(t *testing.T, s *testServer, key string, expected string) {
+	t.Helper()
+	item, err := s.store.Get(key)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	contents, err := item.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer contents.Close()
+	if b, _ := ioutil.ReadAll(contents); string(b) != expected {
+		t.Errorf("Expected %s in %s, got %s", expected, key, b)
+	}
+}
+
+func TestAddContentVersion(t *testing.T) {
+	s := newTestServer(t)
+	if w := putContent(t, s, "c1", "first version", "aaaa"); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	id := s.addLicense(t, "c1", nil)
+
+	if w := putContent(t, s, "c1", "second version", "bbbb"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	c, err := s.idx.Get("c1")
+	if err != nil || c.Version != 2 || c.Sha256 != "bbbb" {
+		t.Fatalf("Expected version 2, got %+v (%v)", c, err)
+	}
+	checkStoredFile(t, s, "c1", "second version")
+	checkStoredFile(t, s, index.ArchiveKey("c1", 1), "first version")
+
+	// the licenses of the content are updated, their history records the new version with unchanged rights
+	l, err := s.lst.Get(id)
+	if err != nil || l.Updated == nil {
+		t.Fatalf("Expected an updated license, got %+v (%v)", l, err)
+	}
+	history, err := s.lst.History(id)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("Expected 1 history entry, got %d (%v)", len(history), err)
+	}
+	if e := history[0]; e.Origin != license.OriginContentVersion || e.Caller != "admin" || !e.Updated.Equal(*l.Updated) ||
+		e.PreviousRights.End != nil || e.NewRights.End != nil {
+		t.Errorf("Unexpected history entry %+v", e)
+	}
+
+	// the storage is restored if the new version cannot be indexed
+	failing := &testServer{store: s.store, idx: failingIndex{s.idx}, lst: s.lst}
+	if w := putContent(t, failing, "c1", "third version", "cccc"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", w.Code)
+	}
+	if c, err = s.idx.Get("c1"); err != nil || c.Version != 2 {
+		t.Errorf("Expected version 2, got %+v (%v)", c, err)
+	}
+	checkStoredFile(t, s, "c1", "second version")
+	if _, err = s.store.Get(index.ArchiveKey("c1", 2)); err == nil {
+		t.Error("Expected the archive of version 2 to be removed")
+	}
+
+	// a new version must be encrypted with the same key
+	f, _ := ioutil.TempFile("", "readium-lcp")
+	f.Close()
+	body, _ := json.Marshal(LcpPublication{ContentKey: bytes.Repeat([]byte{2}, 32), Output: f.Name(), Checksum: "dddd"})
+	r := mux.SetURLVars(httptest.NewRequest("PUT", "/contents/c1", bytes.NewReader(body)), map[string]string{"content_id": "c1"})
+	w := httptest.NewRecorder()
+	AddContent(w, r, s)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+}
+
+func TestGetContentVersion(t *testing.T) {
+	s := newTestServer(t)
+	putContent(t, s, "c1", "first version", "aaaa")
+	putContent(t, s, "c1", "second version", "bbbb")
+
+	get := func(version string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/contents/c1/versions/"+version, nil)
+		r = mux.SetURLVars(r, map[string]string{"content_id": "c1", "version": version})
+		w := httptest.NewRecorder()
+		GetContentVersion(w, r, s)
+		return w
+	}
+	for version, expected := range map[string]string{"1": "first version", "2": "second version"} {
+		w := get(version)
+		if w.Code != http.StatusOK || w.Body.String() != expected {
+			t.Errorf("Expected %s for version %s, got %d %s", expected, version, w.Code, w.Body.String())
+		}
+	}
+	if w := get("3"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w := get("last"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestWrapContentKey(t *testing.T) {
+	s := newTestServer(t)
+	putContent(t, s, "c1", "first version", "aaaa")
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	wrap := func(contentID string, publicKey []byte) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ContentKeyRequest{PublicKey: publicKey})
+		r := httptest.NewRequest("POST", "/contents/"+contentID+"/key", bytes.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"content_id": contentID})
+		w := httptest.NewRecorder()
+		WrapContentKey(w, r, s)
+		return w
+	}
+
+	// the content key is only returned wrapped with the public key of the request
+	w := wrap("c1", publicKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var key WrappedContentKey
+	if err = json.NewDecoder(w.Body).Decode(&key); err != nil {
+		t.Fatal(err)
+	}
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, key.WrappedKey, nil)
+	if err != nil || !bytes.Equal(contentKey, bytes.Repeat([]byte{1}, 32)) {
+		t.Errorf("Unexpected content key %x (%v)", contentKey, err)
+	}
+
+	if w = wrap("c1", []byte("not a key")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if w = wrap("unknown", publicKey); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
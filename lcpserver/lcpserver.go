@@ -5,9 +5,10 @@
 package main
 
 import (
-	"crypto/tls"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -15,8 +16,10 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	auth "github.com/abbot/go-http-auth"
+	"github.com/claudiu/gocron"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,16 +29,20 @@ import (
 	lcpserver "github.com/readium/readium-lcp-server/lcpserver/server"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/sign"
 	"github.com/readium/readium-lcp-server/storage"
 )
 
+// interval between two checks of the modification of the certificate files
+const certificateWatchInterval = time.Minute
+
 func dbFromURI(uri string) (string, string) {
 	parts := strings.Split(uri, "://")
 	return parts[0], parts[1]
 }
 
 func main() {
-	var config_file, dbURI, storagePath, certFile, privKeyFile string
+	var config_file, dbURI string
 	var readonly bool = false
 	var err error
 
@@ -55,16 +62,7 @@ func main() {
 	if dbURI = config.Config.LcpServer.Database; dbURI == "" {
 		dbURI = "sqlite3://file:lcp.sqlite?cache=shared&mode=rwc"
 	}
-	if certFile = config.Config.Certificate.Cert; certFile == "" {
-		panic("Must specify a certificate")
-	}
-	if privKeyFile = config.Config.Certificate.PrivateKey; privKeyFile == "" {
-		panic("Must specify a private key")
-	}
-	cert, err := tls.LoadX509KeyPair(certFile, privKeyFile)
-	if err != nil {
-		panic(err)
-	}
+	signers, certs := newSigners()
 
 	driver, cnxn := dbFromURI(dbURI)
 	db, err := sql.Open(driver, cnxn)
@@ -87,24 +85,18 @@ func main() {
 		panic(err)
 	}
 
+	if config.Config.Profile != "" {
+		if _, err = license.GetProfile(config.Config.Profile); err != nil {
+			panic(err.Error() + ": " + config.Config.Profile)
+		}
+	}
+
 	err = license.CreateDefaultLinks()
 	if err != nil {
 		panic(err)
 	}
 
-	var store storage.Store
-	if mode := config.Config.Storage.Mode; mode == "s3" {
-		s3Conf := s3ConfigFromYAML()
-		store, _ = storage.S3(s3Conf)
-	} else if config.Config.Storage.FileSystem.Directory != "" {
-		storagePath = config.Config.Storage.FileSystem.Directory
-		os.MkdirAll(storagePath, os.ModePerm) //ignore the error, the folder can already exist
-		store = storage.NewFileSystem(storagePath, config.Config.Storage.FileSystem.URL)
-		log.Println("Storage created, path", storagePath, ", URL", config.Config.Storage.FileSystem.URL)
-	} else {
-		store = storage.NoStorage()
-		log.Println("No storage created")
-	}
+	store := newStore(config.Config.Storage)
 
 	packager := pack.NewPackager(store, idx, 4)
 
@@ -119,9 +111,15 @@ func main() {
 	htpasswd := auth.HtpasswdFileProvider(authFile)
 	authenticator := auth.NewBasicAuthenticator("Readium License Content Protection Server", htpasswd)
 
-	HandleSignals()
+	tenants, tenantCerts := newTenants(store)
+	scheduleStorageAudit(idx, store, tenants)
+
+	HandleSignals(append(tenantCerts, certs)...)
 	parsedPort := strconv.Itoa(config.Config.LcpServer.Port)
-	s := lcpserver.New(":"+parsedPort, readonly, &idx, &store, &lst, &cert, packager, authenticator)
+	s, err := lcpserver.New(":"+parsedPort, readonly, &idx, &store, &lst, signers, packager, authenticator, tenants)
+	if err != nil {
+		panic(err)
+	}
 	if readonly {
 		log.Println("License server running in readonly mode on port " + parsedPort)
 	} else {
@@ -136,12 +134,233 @@ func main() {
 
 }
 
-func HandleSignals() {
-	sigChan := make(chan os.Signal)
+// newSigners returns the signers of licenses: a remote signing service if configured,
+// the local certificates otherwise. The local certificates are also returned, as they can be reloaded.
+func newSigners() (sign.SignerProvider, *sign.CertificateSet) {
+
+	if remote := config.Config.RemoteSigning; remote.URL != "" {
+		if remote.Cert == "" {
+			panic("Must specify the certificate of the remote signing service")
+		}
+		signer, err := sign.NewRemoteSigner(remote.Cert, sign.RemoteSignerOptions{
+			URL:      remote.URL,
+			KeyID:    remote.KeyID,
+			Username: remote.Username,
+			Password: remote.Password,
+			Timeout:  time.Duration(remote.Timeout) * time.Second,
+			Retries:  remote.Retries,
+		})
+		if err != nil {
+			panic(err)
+		}
+		log.Println("Licenses signed by the remote signing service " + remote.URL)
+		return signer, nil
+	}
+
+	// the certificate section and the certificates list can be combined
+	certs := newCertificateSet(append([]config.Certificate{config.Config.Certificate}, config.Config.Certificates...))
+	return certs, certs
+}
+
+// newCertificateSet loads signing certificates, which are reloaded when a file is modified
+func newCertificateSet(certificates []config.Certificate) *sign.CertificateSet {
+
+	var keyPairs []sign.KeyPairFile
+	for _, c := range certificates {
+		if c.Cert == "" && c.PrivateKey == "" {
+			continue
+		}
+		if c.Cert == "" {
+			panic("Must specify a certificate")
+		}
+		if c.PrivateKey == "" {
+			panic("Must specify a private key")
+		}
+		keyPairs = append(keyPairs, sign.KeyPairFile{Cert: c.Cert, PrivateKey: c.PrivateKey})
+	}
+	if len(keyPairs) == 0 {
+		panic("Must specify a certificate")
+	}
+	certs, err := sign.LoadCertificateSet(keyPairs)
+	if err != nil {
+		panic(err)
+	}
+	// reload the certificates when a file is modified
+	go certs.Watch(certificateWatchInterval, nil)
+	return certs
+}
+
+// newStore creates the storage of encrypted publications
+func newStore(conf config.Storage) storage.Store {
+
+	store, err := storage.New(conf)
+	if err != nil {
+		panic(err)
+	}
+	switch {
+	case conf.Mode != "":
+		log.Println("Storage created, mode", conf.Mode)
+	case conf.FileSystem.Directory != "":
+		log.Println("Storage created, path", conf.FileSystem.Directory, ", URL", conf.FileSystem.URL)
+	default:
+		log.Println("No storage created")
+	}
+	return store
+}
+
+// newTenants creates the tenants defined in the configuration, and returns their signing certificates.
+// A tenant without storage configuration uses the main storage; a tenant without links uses the links of the license section.
+func newTenants(mainStore storage.Store) ([]lcpserver.Tenant, []*sign.CertificateSet) {
+
+	var tenants []lcpserver.Tenant
+	var certSets []*sign.CertificateSet
+	ids := make(map[string]bool)
+	for _, t := range config.Config.Tenants {
+		if t.ID == "" || ids[t.ID] {
+			panic("Must specify a unique id for every tenant")
+		}
+		ids[t.ID] = true
+		if t.ProviderUri == "" {
+			panic("Must specify the provider uri of tenant " + t.ID)
+		}
+		if t.AuthFile == "" {
+			panic("Must have a passwords file for tenant " + t.ID)
+		}
+		if _, err := os.Stat(t.AuthFile); err != nil {
+			panic(err)
+		}
+		certs := newCertificateSet(append([]config.Certificate{t.Certificate}, t.Certificates...))
+		certSets = append(certSets, certs)
+
+		store, storageURL := mainStore, config.Config.Storage.FileSystem.URL
+		if t.Storage.IsSet() {
+			store, storageURL = newStore(t.Storage), t.Storage.FileSystem.URL
+		}
+		// the links of the license section are used by default
+		configLinks := t.Links
+		if len(configLinks) == 0 {
+			configLinks = config.Config.License.Links
+		}
+		links, err := license.NewDefaultLinks(configLinks, storageURL)
+		if err != nil {
+			panic(err)
+		}
+		// the profile of the configuration is used by default
+		var profile license.Profile
+		if t.Profile != "" {
+			if profile, err = license.GetProfile(t.Profile); err != nil {
+				panic(err.Error() + ": " + t.Profile)
+			}
+		}
+		tenants = append(tenants, lcpserver.Tenant{
+			ID:          t.ID,
+			PathPrefix:  t.PathPrefix,
+			ProviderURI: t.ProviderUri,
+			Links:       links,
+			Store:       store,
+			Signers:     certs,
+			Profile:     profile,
+			Auth:        auth.NewBasicAuthenticator("Readium License Content Protection Server", auth.HtpasswdFileProvider(t.AuthFile)),
+		})
+		log.Println("Tenant " + t.ID + " created, provider " + t.ProviderUri)
+	}
+	return tenants, certSets
+}
+
+// storageAudit is the audit of a storage against the contents of the tenants using it
+type storageAudit struct {
+	name    string
+	store   storage.Store
+	indexes []index.Index
+}
+
+// scheduleStorageAudit audits the storages every configured interval, if storage audits are enabled.
+// The main storage is checked against the contents of the default tenant and of the tenants without storage.
+func scheduleStorageAudit(idx index.Index, mainStore storage.Store, tenants []lcpserver.Tenant) {
+
+	interval := config.Config.StorageAudit.Interval
+	if interval <= 0 {
+		return
+	}
+	var audits []storageAudit
+	if config.Config.Storage.IsSet() {
+		audits = append(audits, storageAudit{name: "main", store: mainStore, indexes: []index.Index{tenantIndex(idx, "")}})
+	}
+	// the tenants are created in the order of the configuration
+	for i, t := range tenants {
+		if !config.Config.Tenants[i].Storage.IsSet() {
+			if len(audits) > 0 && audits[0].name == "main" {
+				audits[0].indexes = append(audits[0].indexes, tenantIndex(idx, t.ID))
+			}
+			continue
+		}
+		audits = append(audits, storageAudit{name: "tenant " + t.ID, store: t.Store, indexes: []index.Index{tenantIndex(idx, t.ID)}})
+	}
+	if len(audits) == 0 {
+		log.Println("No storage to audit")
+		return
+	}
+	gocron.Every(uint64(interval)).Hours().Do(runStorageAudit, audits)
+	gocron.Start()
+	log.Println("Storage audit scheduled every", interval, "hours")
+}
+
+// tenantIndex returns the view of the index restricted to a tenant
+func tenantIndex(idx index.Index, tenantID string) index.Index {
+	t, err := idx.Tenant(tenantID)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// runStorageAudit audits the storages, then logs the report or writes it to the configured file
+func runStorageAudit(audits []storageAudit) {
+
+	reports := make(map[string]index.AuditReport)
+	for _, a := range audits {
+		report, err := index.Audit(a.store, a.indexes...)
+		if err != nil {
+			log.Println("Error auditing the " + a.name + " storage: " + err.Error())
+			continue
+		}
+		log.Println("Storage audit of the", a.name, "storage:", report.Checked, "items checked,",
+			len(report.Issues), "issues,", len(report.Orphans), "orphans")
+		reports[a.name] = report
+	}
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		log.Println("Error encoding the storage audit report: " + err.Error())
+		return
+	}
+	if path := config.Config.StorageAudit.Report; path != "" {
+		if err = ioutil.WriteFile(path, data, 0644); err != nil {
+			log.Println("Error writing the storage audit report: " + err.Error())
+		}
+		return
+	}
+	log.Println(string(data))
+}
+
+// HandleSignals dumps the stacks on SIGQUIT, reloads the local signing certificates on SIGHUP
+// and shuts down on SIGINT and SIGTERM
+func HandleSignals(certSets ...*sign.CertificateSet) {
+	sigChan := make(chan os.Signal, 1)
 	go func() {
 		stacktrace := make([]byte, 1<<20)
 		for sig := range sigChan {
 			switch sig {
+			case syscall.SIGHUP:
+				for _, certs := range certSets {
+					if certs == nil {
+						continue
+					}
+					if err := certs.Reload(); err != nil {
+						log.Println("Error reloading the signing certificates: " + err.Error())
+					} else {
+						log.Println("Signing certificates reloaded")
+					}
+				}
 			case syscall.SIGQUIT:
 				length := runtime.Stack(stacktrace, true)
 				fmt.Println(string(stacktrace[:length]))
@@ -153,22 +372,5 @@ func HandleSignals() {
 			}
 		}
 	}()
-	signal.Notify(sigChan, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
-}
-
-func s3ConfigFromYAML() storage.S3Config {
-	s3config := storage.S3Config{}
-
-	s3config.ID = config.Config.Storage.AccessId
-	s3config.Secret = config.Config.Storage.Secret
-	s3config.Token = config.Config.Storage.Token
-
-	s3config.Endpoint = config.Config.Storage.Endpoint
-	s3config.Bucket = config.Config.Storage.Bucket
-	s3config.Region = config.Config.Storage.Region
-
-	s3config.DisableSSL = config.Config.Storage.DisableSSL
-	s3config.ForcePathStyle = config.Config.Storage.PathStyle
-
-	return s3config
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
 }
@@ -26,7 +26,6 @@
 package lcpserver
 
 import (
-	"crypto/tls"
 	"net/http"
 	"time"
 
@@ -34,21 +33,86 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/readium/readium-lcp-server/api"
+	"github.com/readium/readium-lcp-server/config"
 	"github.com/readium/readium-lcp-server/index"
 	apilcp "github.com/readium/readium-lcp-server/lcpserver/api"
 	"github.com/readium/readium-lcp-server/license"
 	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/sign"
 	"github.com/readium/readium-lcp-server/storage"
 )
 
+// number of packaging workers of a tenant
+const packagerConcurrency = 4
+
 type Server struct {
 	http.Server
 	readonly bool
 	idx      *index.Index
 	st       *storage.Store
 	lst      *license.Store
-	cert     *tls.Certificate
+	signers  sign.SignerProvider
 	source   pack.ManualSource
+	// tenants and view of the default tenant, if tenants are configured
+	tenants       []*tenantServer
+	defaultTenant apilcp.Server
+}
+
+// Tenant is a publisher hosted by the server, with its own provider, signing certificates, default links,
+// storage, license profile and credentials. Its contents and licenses are scoped in the index and license store.
+type Tenant struct {
+	ID          string
+	PathPrefix  string
+	ProviderURI string
+	Links       map[string]string
+	Store       storage.Store
+	Signers     sign.SignerProvider
+	Profile     license.Profile
+	Auth        *auth.BasicAuth
+}
+
+// tenantServer is the view of the server used by the handlers of a tenant
+type tenantServer struct {
+	tenant  Tenant
+	idx     index.Index
+	lst     license.Store
+	source  pack.ManualSource
+	signers sign.SignerProvider
+}
+
+func (t *tenantServer) Store() storage.Store {
+	return t.tenant.Store
+}
+
+func (t *tenantServer) Index() index.Index {
+	return t.idx
+}
+
+func (t *tenantServer) Licenses() license.Store {
+	return t.lst
+}
+
+func (t *tenantServer) Signers() sign.SignerProvider {
+	return t.signers
+}
+
+func (t *tenantServer) Source() *pack.ManualSource {
+	return &t.source
+}
+
+func (t *tenantServer) DefaultLinks() map[string]string {
+	return t.tenant.Links
+}
+
+func (t *tenantServer) Provider() string {
+	return t.tenant.ProviderURI
+}
+
+func (t *tenantServer) Profile() license.Profile {
+	if t.tenant.Profile != nil {
+		return t.tenant.Profile
+	}
+	return license.DefaultProfile()
 }
 
 func (s *Server) Store() storage.Store {
@@ -63,15 +127,27 @@ func (s *Server) Licenses() license.Store {
 	return *s.lst
 }
 
-func (s *Server) Certificate() *tls.Certificate {
-	return s.cert
+func (s *Server) Signers() sign.SignerProvider {
+	return s.signers
 }
 
 func (s *Server) Source() *pack.ManualSource {
 	return &s.source
 }
 
-func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, lst *license.Store, cert *tls.Certificate, packager *pack.Packager, basicAuth *auth.BasicAuth) *Server {
+func (s *Server) DefaultLinks() map[string]string {
+	return license.DefaultLinks
+}
+
+func (s *Server) Provider() string {
+	return ""
+}
+
+func (s *Server) Profile() license.Profile {
+	return license.DefaultProfile()
+}
+
+func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, lst *license.Store, signers sign.SignerProvider, packager *pack.Packager, basicAuth *auth.BasicAuth, tenants []Tenant) (*Server, error) {
 
 	sr := api.CreateServerRouter("")
 
@@ -87,73 +163,205 @@ func New(bindAddr string, readonly bool, idx *index.Index, st *storage.Store, ls
 		idx:      idx,
 		st:       st,
 		lst:      lst,
-		cert:     cert,
+		signers:  signers,
 		source:   pack.ManualSource{},
 	}
 
+	// the contents and licenses of the default tenant are separated from the ones of other tenants
+	var public apilcp.Server = s
+	if len(tenants) > 0 {
+		defaultTenant, err := s.newTenantServer(Tenant{Links: license.DefaultLinks, Store: *st, Signers: signers}, 0)
+		if err != nil {
+			return nil, err
+		}
+		defaultTenant.source.Feed(packager.Incoming)
+		s.defaultTenant = defaultTenant
+		public = defaultTenant
+	}
+	for _, t := range tenants {
+		ts, err := s.newTenantServer(t, packagerConcurrency)
+		if err != nil {
+			return nil, err
+		}
+		s.tenants = append(s.tenants, ts)
+	}
+
 	// Route.PathPrefix: http://www.gorillatoolkit.org/pkg/mux#Route.PathPrefix
 	// Route.Subrouter: http://www.gorillatoolkit.org/pkg/mux#Route.Subrouter
 	// Router.StrictSlash: http://www.gorillatoolkit.org/pkg/mux#Router.StrictSlash
 
+	// routes of tenants selected by a URL prefix
+	for _, ts := range s.tenants {
+		if ts.tenant.PathPrefix == "" {
+			continue
+		}
+		ts := ts
+		tenantRouter := sr.R.PathPrefix(ts.tenant.PathPrefix).Subrouter()
+		s.setRoutes(tenantRouter, ts, func(w http.ResponseWriter, r *http.Request) apilcp.Server {
+			if api.CheckAuth(ts.tenant.Auth, w, r) {
+				return ts
+			}
+			return nil
+		})
+	}
+	// other routes, the tenant is selected by the identity of the caller
+	s.setRoutes(sr.R, public, func(w http.ResponseWriter, r *http.Request) apilcp.Server {
+		return s.authenticate(w, r, basicAuth)
+	})
+
+	s.source.Feed(packager.Incoming)
+	return s, nil
+}
+
+// newTenantServer creates the view of the server used by a tenant
+func (s *Server) newTenantServer(t Tenant, concurrency int) (*tenantServer, error) {
+
+	idx, err := s.Index().Tenant(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	ts := &tenantServer{tenant: t, idx: idx, lst: s.Licenses().Tenant(t.ID), signers: t.Signers}
+	// the contents of a tenant are packaged to its own storage and index
+	if concurrency > 0 {
+		ts.source.Feed(pack.NewPackager(t.Store, idx, concurrency).Incoming)
+	}
+	return ts, nil
+}
+
+// authenticate checks the credentials of the caller and returns the view of the server it can access:
+// the whole server if no tenant is configured, the default tenant for the users of the main password file,
+// a tenant for the users of its password file. The License Status Server and the frontend get the view of the tenant
+// owning the license or content of the request, so that licenses are built with the certificates, links and storage
+// of this tenant. It returns nil if the caller is not authenticated.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request, basicAuth *auth.BasicAuth) apilcp.Server {
+
+	if len(s.tenants) == 0 {
+		if api.CheckAuth(basicAuth, w, r) {
+			return s
+		}
+		return nil
+	}
+	if username := basicAuth.CheckAuth(r); username != "" {
+		if username == config.Config.LcpUpdateAuth.Username {
+			return s.ownerTenant(r)
+		}
+		return s.defaultTenant
+	}
+	for _, ts := range s.tenants {
+		if ts.tenant.Auth.CheckAuth(r) != "" {
+			return ts
+		}
+	}
+	// report the authentication failure
+	api.CheckAuth(basicAuth, w, r)
+	return nil
+}
+
+// ownerTenant returns the view of the tenant owning the license or content of a request,
+// from the tenant column of the license or content row; the default tenant by default.
+func (s *Server) ownerTenant(r *http.Request) apilcp.Server {
+
+	vars := mux.Vars(r)
+	tenantID, err := "", error(nil)
+	if licenseID := vars["license_id"]; licenseID != "" {
+		tenantID, err = s.Licenses().TenantOf(licenseID)
+	} else if contentID := vars["content_id"]; contentID != "" {
+		tenantID, err = s.Index().TenantOf(contentID)
+	}
+	// an unknown license or content is reported by the handler
+	if err != nil || tenantID == "" {
+		return s.defaultTenant
+	}
+	for _, ts := range s.tenants {
+		if ts.tenant.ID == tenantID {
+			return ts
+		}
+	}
+	return s.defaultTenant
+}
+
+// setRoutes sets the routes of the server.
+// Public handlers are called with the public view of the server,
+// private handlers with the view returned by the authentication function.
+func (s *Server) setRoutes(router *mux.Router, public apilcp.Server, authenticate func(w http.ResponseWriter, r *http.Request) apilcp.Server) {
+
+	readonly := s.readonly
+
+	handleFunc := func(router *mux.Router, route string, fn HandlerFunc) *mux.Route {
+		return router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+			fn(w, r, public)
+		})
+	}
+	handlePrivateFunc := func(router *mux.Router, route string, fn HandlerFunc) *mux.Route {
+		return router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+			if scoped := authenticate(w, r); scoped != nil {
+				fn(w, r, scoped)
+			}
+		})
+	}
+
 	// methods related to EPUB encrypted content
 
 	contentRoutesPathPrefix := "/contents"
-	contentRoutes := sr.R.PathPrefix(contentRoutesPathPrefix).Subrouter().StrictSlash(false)
+	contentRoutes := router.PathPrefix(contentRoutesPathPrefix).Subrouter().StrictSlash(false)
 
-	s.handleFunc(sr.R, contentRoutesPathPrefix, apilcp.ListContents).Methods("GET")
+	handleFunc(router, contentRoutesPathPrefix, apilcp.ListContents).Methods("GET")
 
 	// get encrypted content by content id (a uuid)
-	s.handleFunc(contentRoutes, "/{content_id}", apilcp.GetContent).Methods("GET")
+	handleFunc(contentRoutes, "/{content_id}", apilcp.GetContent).Methods("GET")
 	// get all licenses associated with a given content
-	s.handlePrivateFunc(contentRoutes, "/{content_id}/licenses", apilcp.ListLicensesForContent, basicAuth).Methods("GET")
+	handlePrivateFunc(contentRoutes, "/{content_id}/licenses", apilcp.ListLicensesForContent).Methods("GET")
+	// get the content key wrapped with the public key of the caller, for encrypting a new version of the content
+	handlePrivateFunc(contentRoutes, "/{content_id}/key", apilcp.WrapContentKey).Methods("POST")
+	// list the versions of a content, get the encrypted file of a version
+	handleFunc(contentRoutes, "/{content_id}/versions", apilcp.ListContentVersions).Methods("GET")
+	handleFunc(contentRoutes, "/{content_id}/versions/{version}", apilcp.GetContentVersion).Methods("GET")
 
 	if !readonly {
 		// put content to the storage
-		s.handlePrivateFunc(contentRoutes, "/{content_id}", apilcp.AddContent, basicAuth).Methods("PUT")
+		handlePrivateFunc(contentRoutes, "/{content_id}", apilcp.AddContent).Methods("PUT")
+		// delete a content, its encrypted file and its licenses
+		handlePrivateFunc(contentRoutes, "/{content_id}", apilcp.DeleteContent).Methods("DELETE")
 		// generate a license for given content
-		s.handlePrivateFunc(contentRoutes, "/{content_id}/license", apilcp.GenerateLicense, basicAuth).Methods("POST")
+		handlePrivateFunc(contentRoutes, "/{content_id}/license", apilcp.GenerateLicense).Methods("POST")
 		// deprecated, from a typo in the lcp server spec
-		s.handlePrivateFunc(contentRoutes, "/{content_id}/licenses", apilcp.GenerateLicense, basicAuth).Methods("POST")
+		handlePrivateFunc(contentRoutes, "/{content_id}/licenses", apilcp.GenerateLicense).Methods("POST")
 		// generate a licensed publication
-		s.handlePrivateFunc(contentRoutes, "/{content_id}/publication", apilcp.GenerateLicensedPublication, basicAuth).Methods("POST")
+		handlePrivateFunc(contentRoutes, "/{content_id}/publication", apilcp.GenerateLicensedPublication).Methods("POST")
 		// deprecated, from a typo in the lcp server spec
-		s.handlePrivateFunc(contentRoutes, "/{content_id}/publications", apilcp.GenerateLicensedPublication, basicAuth).Methods("POST")
+		handlePrivateFunc(contentRoutes, "/{content_id}/publications", apilcp.GenerateLicensedPublication).Methods("POST")
 	}
 
 	// methods related to licenses
 
 	licenseRoutesPathPrefix := "/licenses"
-	licenseRoutes := sr.R.PathPrefix(licenseRoutesPathPrefix).Subrouter().StrictSlash(false)
+	licenseRoutes := router.PathPrefix(licenseRoutesPathPrefix).Subrouter().StrictSlash(false)
 
-	s.handlePrivateFunc(sr.R, licenseRoutesPathPrefix, apilcp.ListLicenses, basicAuth).Methods("GET")
+	handlePrivateFunc(router, licenseRoutesPathPrefix, apilcp.ListLicenses).Methods("GET")
+	if !readonly {
+		// generate licenses in bulk
+		handlePrivateFunc(router, licenseRoutesPathPrefix, apilcp.GenerateLicenses).Methods("POST")
+	}
 	// get a license
-	s.handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.GetLicense, basicAuth).Methods("GET")
-	s.handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.GetLicense, basicAuth).Methods("POST")
+	handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.GetLicense).Methods("GET")
+	handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.GetLicense).Methods("POST")
 	// get a licensed publication via a license id
-	s.handlePrivateFunc(licenseRoutes, "/{license_id}/publication", apilcp.GetLicensedPublication, basicAuth).Methods("POST")
+	handlePrivateFunc(licenseRoutes, "/{license_id}/publication", apilcp.GetLicensedPublication).Methods("POST")
+	// get the history of the changes of a license
+	handlePrivateFunc(licenseRoutes, "/{license_id}/history", apilcp.GetLicenseHistory).Methods("GET")
 	if !readonly {
 		// update a license
-		s.handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.UpdateLicense, basicAuth).Methods("PATCH")
+		handlePrivateFunc(licenseRoutes, "/{license_id}", apilcp.UpdateLicense).Methods("PATCH")
 	}
 
-	s.source.Feed(packager.Incoming)
-	return s
+	// methods related to users
+
+	if !readonly {
+		// re-key the licenses of a user after a passphrase change
+		handlePrivateFunc(router, "/users/{user_id}/passphrase", apilcp.RekeyUserLicenses).Methods("PUT")
+	}
 }
 
 type HandlerFunc func(w http.ResponseWriter, r *http.Request, s apilcp.Server)
 
-func (s *Server) handleFunc(router *mux.Router, route string, fn HandlerFunc) *mux.Route {
-	return router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
-		fn(w, r, s)
-	})
-}
-
 type HandlerPrivateFunc func(w http.ResponseWriter, r *auth.AuthenticatedRequest, s apilcp.Server)
-
-func (s *Server) handlePrivateFunc(router *mux.Router, route string, fn HandlerFunc, authenticator *auth.BasicAuth) *mux.Route {
-	return router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
-		if api.CheckAuth(authenticator, w, r) {
-			fn(w, r, s)
-		}
-	})
-}
@@ -26,8 +26,77 @@
 package lcpserver
 
 import (
+	"database/sql"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/index"
+	apilcp "github.com/readium/readium-lcp-server/lcpserver/api"
+	"github.com/readium/readium-lcp-server/license"
+	"github.com/readium/readium-lcp-server/pack"
+	"github.com/readium/readium-lcp-server/storage"
 )
 
 func TestSetup(t *testing.T) {
 }
+
+func TestOwnerTenant(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	idx, err := index.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst, err := license.NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storage.NoStorage()
+	packager := pack.NewPackager(st, idx, 1)
+	tenants := []Tenant{{ID: "a", ProviderURI: "https://a.example.com", Store: st}, {ID: "b", ProviderURI: "https://b.example.com", Store: st}}
+	s, err := New(":0", false, &idx, &st, &lst, nil, packager, nil, tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenantIdx, _ := idx.Tenant("a")
+	if err = tenantIdx.Add(index.Content{ID: "content-a", EncryptionKey: []byte("1234"), Location: "a.epub"}); err != nil {
+		t.Fatal(err)
+	}
+	l := license.License{User: license.UserInfo{ID: "user"}, Rights: new(license.UserRights)}
+	license.Initialize("content-a", &l)
+	if err = lst.Tenant("b").Add(l); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := func(vars map[string]string) string {
+		r := mux.SetURLVars(httptest.NewRequest("GET", "/", nil), vars)
+		view := s.ownerTenant(r)
+		if view == apilcp.Server(s) {
+			t.Fatal("The unscoped server should not be used when tenants are configured")
+		}
+		return view.Provider()
+	}
+	if p := provider(map[string]string{"content_id": "content-a"}); p != "https://a.example.com" {
+		t.Errorf("Expected the provider of tenant a, got %s", p)
+	}
+	if p := provider(map[string]string{"license_id": l.ID}); p != "https://b.example.com" {
+		t.Errorf("Expected the provider of tenant b, got %s", p)
+	}
+	// unknown resources and requests without resource are processed by the default tenant
+	if p := provider(map[string]string{"license_id": "unknown"}); p != "" {
+		t.Errorf("Expected the default tenant, got %s", p)
+	}
+	if p := provider(nil); p != "" {
+		t.Errorf("Expected the default tenant, got %s", p)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Origins of a license change
+const (
+	OriginPatch     = "patch"      // direct update of the license
+	OriginRekey     = "rekey"      // passphrase change of the user
+	OriginLsdRenew  = "lsd_renew"  // renew by the License Status Server
+	OriginLsdReturn = "lsd_return" // return by the License Status Server
+	OriginLsdRevoke = "lsd_revoke" // cancellation or revocation by the License Status Server
+	// OriginContentVersion is a new version of the content of the license
+	OriginContentVersion = "content_version"
+)
+
+// Change identifies the caller and the api at the origin of a license change
+type Change struct {
+	Caller string
+	Origin string
+}
+
+// HistoryEntry is an immutable record of a license change
+type HistoryEntry struct {
+	LicenseID      string      `json:"license_id"`
+	Updated        time.Time   `json:"updated"`
+	Caller         string      `json:"caller,omitempty"`
+	Origin         string      `json:"origin"`
+	PreviousRights *UserRights `json:"previous_rights"`
+	NewRights      *UserRights `json:"new_rights"`
+}
+
+// IsOrigin checks if a string is a known origin of license changes
+func IsOrigin(origin string) bool {
+	switch origin {
+	case OriginPatch, OriginRekey, OriginLsdRenew, OriginLsdReturn, OriginLsdRevoke, OriginContentVersion:
+		return true
+	}
+	return false
+}
+
+// updateWithHistory updates a license at a given date and records the change in the license history, in a single transaction.
+// The update function executes the update in the transaction and returns the number of updated rows.
+func (s *sqlStore) updateWithHistory(id string, newRights *UserRights, c Change, updated time.Time, update func(tx *sql.Tx, updated time.Time) (sql.Result, error)) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	previous := new(UserRights)
+	row := tx.QueryRow(`SELECT rights_print, rights_copy, rights_start, rights_end FROM license
+	WHERE id=?`+s.tenantCond("AND"), s.tenantArgs(0, id)...)
+	err = row.Scan(&previous.Print, &previous.Copy, &previous.Start, &previous.End)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if _, err = update(tx, updated); err != nil {
+		return err
+	}
+	if newRights == nil {
+		newRights = new(UserRights)
+	}
+	if err = insertHistory(tx, id, updated, c, previous, newRights); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertHistory records a license change in the license history, in the transaction of the change
+func insertHistory(tx *sql.Tx, id string, updated time.Time, c Change, previous, newRights *UserRights) error {
+	_, err := tx.Exec(`INSERT INTO license_history (license_id, updated, caller, origin,
+	previous_print, previous_copy, previous_start, previous_end, new_print, new_copy, new_start, new_end)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, updated, c.Caller, c.Origin,
+		previous.Print, previous.Copy, previous.Start, previous.End,
+		newRights.Print, newRights.Copy, newRights.Start, newRights.End)
+	return err
+}
+
+// History returns the changes of a license, in chronological order
+func (s *sqlStore) History(id string) ([]HistoryEntry, error) {
+
+	// check that the license is visible
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT license_id, updated, caller, origin,
+	previous_print, previous_copy, previous_start, previous_end, new_print, new_copy, new_start, new_end
+	FROM license_history WHERE license_id=? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		e := HistoryEntry{PreviousRights: new(UserRights), NewRights: new(UserRights)}
+		err = rows.Scan(&e.LicenseID, &e.Updated, &e.Caller, &e.Origin,
+			&e.PreviousRights.Print, &e.PreviousRights.Copy, &e.PreviousRights.Start, &e.PreviousRights.End,
+			&e.NewRights.Print, &e.NewRights.Copy, &e.NewRights.Start, &e.NewRights.End)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+const historyTableDef = "CREATE TABLE IF NOT EXISTS license_history (" +
+	"id integer PRIMARY KEY AUTOINCREMENT," +
+	"license_id varchar(255) NOT NULL," +
+	"updated datetime NOT NULL," +
+	"caller varchar(255) NOT NULL DEFAULT ''," +
+	"origin varchar(32) NOT NULL," +
+	"previous_print int(11) DEFAULT NULL," +
+	"previous_copy int(11) DEFAULT NULL," +
+	"previous_start datetime DEFAULT NULL," +
+	"previous_end datetime DEFAULT NULL," +
+	"new_print int(11) DEFAULT NULL," +
+	"new_copy int(11) DEFAULT NULL," +
+	"new_start datetime DEFAULT NULL," +
+	"new_end datetime DEFAULT NULL," +
+	"FOREIGN KEY(license_id) REFERENCES license(id))"
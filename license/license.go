@@ -7,7 +7,6 @@ package license
 import (
 	"bytes"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -49,6 +48,8 @@ type Encryption struct {
 	Profile    string     `json:"profile,omitempty"`
 	ContentKey ContentKey `json:"content_key"`
 	UserKey    UserKey    `json:"user_key"`
+	// ResourceAlgorithm is an extension property, ignored by the reading systems which do not know it
+	ResourceAlgorithm string `json:"resource_algorithm,omitempty"`
 }
 
 type Link struct {
@@ -63,11 +64,16 @@ type Link struct {
 	Checksum string `json:"hash,omitempty"`
 }
 
+// UserInfo holds the user properties of a license.
+// Extensions are additional user properties, inlined in the user object; they may be encrypted.
 type UserInfo struct {
-	ID        string   `json:"id"`
-	Email     string   `json:"email,omitempty"`
-	Name      string   `json:"name,omitempty"`
-	Encrypted []string `json:"encrypted,omitempty"`
+	ID         string                 `json:"id"`
+	Email      string                 `json:"email,omitempty"`
+	Name       string                 `json:"name,omitempty"`
+	Encrypted  []string               `json:"encrypted,omitempty"`
+	Extensions map[string]interface{} `json:"-"`
+	// clear values of the extensions, kept when they are encrypted
+	clearExtensions map[string]interface{}
 }
 
 type UserRights struct {
@@ -90,6 +96,8 @@ type License struct {
 	Rights     *UserRights     `json:"rights,omitempty"`
 	Signature  *sign.Signature `json:"signature,omitempty"`
 	ContentID  string          `json:"-"`
+	// name of the registered profile the license is built with
+	ProfileName string `json:"-"`
 }
 
 type LicenseReport struct {
@@ -128,15 +136,14 @@ func (profile EncryptionProfile) String() string {
 
 // SetLicenseProfile sets the license profile from config
 func SetLicenseProfile(l *License) {
+	SetLicenseProfileWith(l, DefaultProfile())
+}
 
-	// possible profiles are basic and 1.0
-	var ep EncryptionProfile
-	if config.Config.Profile == "1.0" {
-		ep = V1Profile
-	} else {
-		ep = BasicProfile
-	}
-	l.Encryption.Profile = ep.String()
+// SetLicenseProfileWith sets the license profile from a registered profile.
+// The profile uri stays the standard one whatever the algorithm of the publication resources;
+// a GCM algorithm is reported by EncryptLicenseFields in the resource_algorithm extension.
+func SetLicenseProfileWith(l *License, p Profile) {
+	l.Encryption.Profile = p.URI()
 }
 
 // newUUID generates a random UUID according to RFC 4122
@@ -170,67 +177,78 @@ func Initialize(contentID string, l *License) {
 // CreateDefaultLinks inits the global var DefaultLinks from config data
 func CreateDefaultLinks() error {
 
-	configLinks := config.Config.License.Links
+	var err error
 	// the storage url should now be in the storage section.
-	storageURL := config.Config.Storage.FileSystem.URL
+	DefaultLinks, err = NewDefaultLinks(config.Config.License.Links, config.Config.Storage.FileSystem.URL)
+	return err
+}
+
+// NewDefaultLinks returns default license links from configured links and a storage url
+func NewDefaultLinks(configLinks map[string]string, storageURL string) (map[string]string, error) {
 
-	DefaultLinks = make(map[string]string)
+	defaultLinks := make(map[string]string)
 
 	for key := range configLinks {
-		DefaultLinks[key] = configLinks[key]
+		defaultLinks[key] = configLinks[key]
 	}
 	// this value supercedes a (deprecated) publication link placed in the license section;
 	// keep backward compatibility.
 	if storageURL != "" {
 		u, err := url.Parse(storageURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !strings.HasSuffix(u.Path, "/") {
 			u.Path = u.Path + "/"
 		}
-		DefaultLinks["publication"] = u.String() + "{publication_id}"
+		defaultLinks["publication"] = u.String() + "{publication_id}"
 	}
-	return nil
+	return defaultLinks, nil
 }
 
 // setDefaultLinks sets a Link array from config links
-func setDefaultLinks() []Link {
+func setDefaultLinks(defaultLinks map[string]string) []Link {
 
 	links := new([]Link)
-	for key := range DefaultLinks {
-		link := Link{Href: DefaultLinks[key], Rel: key}
+	for key := range defaultLinks {
+		link := Link{Href: defaultLinks[key], Rel: key}
 		*links = append(*links, link)
 	}
 	return *links
 }
 
 // appendDefaultLinks appends default links to custom links
-func appendDefaultLinks(inLinks *[]Link) []Link {
+func appendDefaultLinks(inLinks *[]Link, defaultLinks map[string]string) []Link {
 
 	if *inLinks == nil {
 		// if there are no custom links in the partial license, set default links
-		return setDefaultLinks()
+		return setDefaultLinks(defaultLinks)
 	} else {
 		// otherwise append default links to custom links.
 		// If a default Link is already present, override the custom links with the default one
 		links := new([]Link)
 		for _, link := range *inLinks {
 			rel := link.Rel
-			if _, exist := DefaultLinks[rel]; !exist {
+			if _, exist := defaultLinks[rel]; !exist {
 				*links = append(*links, link)
 			}
 		}
-		return append(*links, setDefaultLinks()...)
+		return append(*links, setDefaultLinks(defaultLinks)...)
 	}
 }
 
-// SetLicenseLinks sets publication and status links
+// SetLicenseLinks sets publication and status links from the configured default links
 // l.ContentID must have been set before the call
 func SetLicenseLinks(l *License, c index.Content) error {
+	return SetLicenseLinksWith(l, c, DefaultLinks)
+}
+
+// SetLicenseLinksWith sets publication and status links from given default links
+// l.ContentID must have been set before the call
+func SetLicenseLinksWith(l *License, c index.Content, defaultLinks map[string]string) error {
 
 	// append default links to custom links
-	l.Links = appendDefaultLinks(&l.Links)
+	l.Links = appendDefaultLinks(&l.Links, defaultLinks)
 
 	// check if the publication link is in the content database
 	hasPubLink, err := isURL(c.Location)
@@ -296,31 +314,47 @@ func expandUriTemplate(uriTemplate, variable, value string) string {
 	return expanded
 }
 
-// EncryptLicenseFields sets the content key, encrypted user info and key check
+// EncryptLicenseFields sets the content key, encrypted user info and key check,
+// using the registered profile matching the profile of the license (the default profile if not set)
 func EncryptLicenseFields(l *License, c index.Content) error {
 
+	p := DefaultProfile()
+	if l.Encryption.Profile != "" {
+		var err error
+		if p, err = profileForURI(l.Encryption.Profile); err != nil {
+			return fmt.Errorf("%w: %s", err, l.Encryption.Profile)
+		}
+	}
+
 	// generate the user key
-	encryptionKey := GenerateUserKey(l.Encryption.UserKey)
+	encryptionKey, err := p.UserKey(l.Encryption.UserKey.Value)
+	if err != nil {
+		return err
+	}
 
 	// empty the passphrase hash to avoid sending it back to the user
 	l.Encryption.UserKey.Value = nil
 	l.Encryption.UserKey.HexValue = ""
 
 	// encrypt the content key with the user key
-	encrypterContentKey := crypto.NewAESEncrypter_CONTENT_KEY()
+	encrypterContentKey := p.ContentKeyEncrypter()
 	l.Encryption.ContentKey.Algorithm = encrypterContentKey.Signature()
 	l.Encryption.ContentKey.Value = encryptKey(encrypterContentKey, c.EncryptionKey, encryptionKey[:])
 
+	// report the algorithm of the publication resources when it is not the CBC algorithm of the profiles
+	l.Encryption.ResourceAlgorithm = ""
+	if c.EncryptionAlgorithm == crypto.NewAESGCMEncrypter().Signature() {
+		l.Encryption.ResourceAlgorithm = c.EncryptionAlgorithm
+	}
+
 	// encrypt the user info fields
-	encrypterFields := crypto.NewAESEncrypter_FIELDS()
-	err := encryptFields(encrypterFields, l, encryptionKey[:])
+	err = encryptFields(p.FieldsEncrypter(), l, encryptionKey[:])
 	if err != nil {
 		return err
 	}
 
 	// build the key check
-	encrypterUserKeyCheck := crypto.NewAESEncrypter_USER_KEY_CHECK()
-	l.Encryption.UserKey.Check, err = buildKeyCheck(l.ID, encrypterUserKeyCheck, encryptionKey[:])
+	l.Encryption.UserKey.Check, err = buildKeyCheck(l.ID, p.KeyCheckEncrypter(), encryptionKey[:])
 	if err != nil {
 		return err
 	}
@@ -335,14 +369,46 @@ func encryptKey(encrypter crypto.Encrypter, key []byte, kek []byte) []byte {
 }
 
 func encryptFields(encrypter crypto.Encrypter, l *License, key []byte) error {
-	for _, toEncrypt := range l.User.Encrypted {
+	// keep the clear extensions, which are stored in the db
+	if l.User.clearExtensions == nil && l.User.Extensions != nil {
+		l.User.clearExtensions = l.User.Extensions
+		l.User.Extensions = make(map[string]interface{}, len(l.User.clearExtensions))
+		for name, value := range l.User.clearExtensions {
+			l.User.Extensions[name] = value
+		}
+	}
+	encryptValue := func(value string) (string, error) {
 		var out bytes.Buffer
-		field := getField(&l.User, toEncrypt)
-		err := encrypter.Encrypt(key[:], bytes.NewBufferString(field.String()), &out)
+		err := encrypter.Encrypt(key[:], bytes.NewBufferString(value), &out)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(out.Bytes()), nil
+	}
+
+	for _, toEncrypt := range l.User.Encrypted {
+		if userProperties[toEncrypt] {
+			field := getField(&l.User, toEncrypt)
+			if !field.IsValid() || field.Kind() != reflect.String {
+				return fmt.Errorf("%w: %s", ErrInvalidEncryptedField, toEncrypt)
+			}
+			value, err := encryptValue(field.String())
+			if err != nil {
+				return err
+			}
+			field.SetString(value)
+			continue
+		}
+		// extension user property, which must be a string
+		value, ok := l.User.clearExtensions[toEncrypt].(string)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidEncryptedField, toEncrypt)
+		}
+		encrypted, err := encryptValue(value)
 		if err != nil {
 			return err
 		}
-		field.Set(reflect.ValueOf(base64.StdEncoding.EncodeToString(out.Bytes())))
+		l.User.Extensions[toEncrypt] = encrypted
 	}
 	return nil
 }
@@ -365,10 +431,14 @@ func buildKeyCheck(licenseID string, encrypter crypto.Encrypter, key []byte) ([]
 	return out.Bytes(), nil
 }
 
-// SignLicense signs a license using the server certificate
-func SignLicense(l *License, cert *tls.Certificate) error {
+// SignLicense signs a license using the signer valid at the date the license was issued or updated
+func SignLicense(l *License, signers sign.SignerProvider) error {
 
-	sig, err := sign.NewSigner(cert)
+	date := l.Issued
+	if l.Updated != nil {
+		date = *l.Updated
+	}
+	sig, err := signers.Signer(date)
 	if err != nil {
 		return err
 	}
@@ -381,6 +451,20 @@ func SignLicense(l *License, cert *tls.Certificate) error {
 	return nil
 }
 
+// VerifyLicense checks the signature of a license, and checks that the signing certificate
+// chains to the provider CA set in the configuration
+func VerifyLicense(l *License) error {
+
+	if config.Config.Certificate.ProviderCA == "" {
+		return errors.New("no provider CA in the configuration")
+	}
+	roots, err := sign.LoadRoots(config.Config.Certificate.ProviderCA)
+	if err != nil {
+		return err
+	}
+	return sign.Verify(l, roots)
+}
+
 func isURL(filePathOrURL string) (bool, error) {
 	url, err := url.Parse(filePathOrURL)
 	if err != nil {
@@ -18,7 +18,7 @@ func TestLicense(t *testing.T) {
 
 	SetLicenseProfile(&l)
 
-	if l.Encryption.Profile != "1.0" && l.Encryption.Profile != "basic" {
-		t.Errorf("Expected '1.0' or 'basic', got %s", l.Encryption.Profile)
+	if l.Encryption.Profile != V1Profile.String() && l.Encryption.Profile != BasicProfile.String() {
+		t.Errorf("Expected '%s' or '%s', got %s", V1Profile, BasicProfile, l.Encryption.Profile)
 	}
 }
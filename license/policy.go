@@ -0,0 +1,95 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/readium/readium-lcp-server/config"
+)
+
+// ErrUnknownPolicy is returned when a license policy is not defined in the configuration
+var ErrUnknownPolicy = errors.New("unknown license policy")
+
+// ApplyPolicy sets the rights of a license from a named policy defined in the configuration.
+// Rights already set in the license take precedence over the policy.
+// The license must be initialized, as dates are relative to the issue date.
+// It returns the end of the potential rights, to be notified to the License Status Server; nil if not set.
+func ApplyPolicy(l *License, name string) (*time.Time, error) {
+
+	policy, ok := config.Config.LicensePolicies[name]
+	if !ok {
+		return nil, ErrUnknownPolicy
+	}
+	startOffset, err := ParsePolicyDuration(policy.StartOffset)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := ParsePolicyDuration(policy.Duration)
+	if err != nil {
+		return nil, err
+	}
+	potentialDuration, err := ParsePolicyDuration(policy.PotentialRightsDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Rights == nil {
+		l.Rights = new(UserRights)
+	}
+	if l.Rights.Print == nil && policy.Print != nil {
+		printLimit := *policy.Print
+		l.Rights.Print = &printLimit
+	}
+	if l.Rights.Copy == nil && policy.Copy != nil {
+		copyLimit := *policy.Copy
+		l.Rights.Copy = &copyLimit
+	}
+	start := l.Issued.Add(startOffset)
+	if l.Rights.Start == nil && (startOffset != 0 || duration != 0) {
+		l.Rights.Start = &start
+	}
+	if l.Rights.Start != nil {
+		start = *l.Rights.Start
+	}
+	if l.Rights.End == nil && duration != 0 {
+		end := start.Add(duration)
+		l.Rights.End = &end
+	}
+
+	if potentialDuration == 0 {
+		return nil, nil
+	}
+	potentialEnd := start.Add(potentialDuration)
+	if l.Rights.End != nil && l.Rights.End.After(potentialEnd) {
+		potentialEnd = *l.Rights.End
+	}
+	return &potentialEnd, nil
+}
+
+// ParsePolicyDuration parses a duration expressed in days ("21d") or as a Go duration ("48h", "90m").
+// An empty string is a zero duration.
+func ParsePolicyDuration(s string) (time.Duration, error) {
+
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid policy duration %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid policy duration %s", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"testing"
+	"time"
+
+	"github.com/readium/readium-lcp-server/config"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	print, copy := int32(10), int32(2000)
+	config.Config.LicensePolicies = map[string]config.LicensePolicy{
+		"loan-21d":     {Print: &print, Copy: &copy, Duration: "21d", PotentialRightsDuration: "60d"},
+		"buy-standard": {Print: &print, Copy: &copy},
+		"preview-2d":   {Print: new(int32), Copy: new(int32), StartOffset: "1h", Duration: "48h"},
+		"invalid":      {Duration: "3 weeks"},
+	}
+	defer func() { config.Config.LicensePolicies = nil }()
+
+	issued := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// a loan
+	l := License{Issued: issued}
+	potentialEnd, err := ApplyPolicy(&l, "loan-21d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *l.Rights.Print != 10 || *l.Rights.Copy != 2000 {
+		t.Errorf("Unexpected print and copy rights: %d, %d", *l.Rights.Print, *l.Rights.Copy)
+	}
+	if !l.Rights.Start.Equal(issued) || !l.Rights.End.Equal(issued.AddDate(0, 0, 21)) {
+		t.Errorf("Unexpected start and end: %v, %v", l.Rights.Start, l.Rights.End)
+	}
+	if potentialEnd == nil || !potentialEnd.Equal(issued.AddDate(0, 0, 60)) {
+		t.Errorf("Unexpected potential rights end: %v", potentialEnd)
+	}
+
+	// a purchase has no dates
+	l = License{Issued: issued}
+	if potentialEnd, err = ApplyPolicy(&l, "buy-standard"); err != nil || potentialEnd != nil {
+		t.Errorf("Expected no potential rights end, got %v, %v", potentialEnd, err)
+	}
+	if l.Rights.Start != nil || l.Rights.End != nil {
+		t.Error("Expected no start and end")
+	}
+
+	// rights set in the request take precedence
+	start := issued.Add(24 * time.Hour)
+	one := int32(1)
+	l = License{Issued: issued, Rights: &UserRights{Print: &one, Start: &start}}
+	if _, err = ApplyPolicy(&l, "preview-2d"); err != nil {
+		t.Fatal(err)
+	}
+	if *l.Rights.Print != 1 || *l.Rights.Copy != 0 {
+		t.Errorf("Unexpected print and copy rights: %d, %d", *l.Rights.Print, *l.Rights.Copy)
+	}
+	if !l.Rights.Start.Equal(start) || !l.Rights.End.Equal(start.Add(48*time.Hour)) {
+		t.Errorf("Unexpected start and end: %v, %v", l.Rights.Start, l.Rights.End)
+	}
+
+	// a start offset
+	l = License{Issued: issued}
+	if _, err = ApplyPolicy(&l, "preview-2d"); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Rights.Start.Equal(issued.Add(time.Hour)) {
+		t.Errorf("Unexpected start: %v", l.Rights.Start)
+	}
+
+	if _, err = ApplyPolicy(&License{Issued: issued}, "unknown"); err != ErrUnknownPolicy {
+		t.Errorf("Expected ErrUnknownPolicy, got %v", err)
+	}
+	if _, err = ApplyPolicy(&License{Issued: issued}, "invalid"); err == nil {
+		t.Error("Expected an invalid duration error")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/crypto"
+)
+
+// ErrUnknownProfile is returned when a license profile is not registered
+var ErrUnknownProfile = errors.New("unknown license profile")
+
+// Names of the built-in profiles
+const (
+	BasicProfileName = "basic"
+	V1ProfileName    = "1.0"
+)
+
+// Profile is an LCP encryption profile.
+// It defines the profile URI set in licenses, how the user key is derived from the passphrase hash,
+// and the encrypters of the content key, the user fields and the key check.
+type Profile interface {
+	URI() string
+	UserKey(passphraseHash []byte) ([]byte, error)
+	ContentKeyEncrypter() crypto.Encrypter
+	FieldsEncrypter() crypto.Encrypter
+	KeyCheckEncrypter() crypto.Encrypter
+}
+
+// aesProfile is an LCP profile using AES-256-CBC encrypters, with a user key derived by a function
+type aesProfile struct {
+	uri     string
+	userKey func(passphraseHash []byte) []byte
+}
+
+func (p aesProfile) URI() string {
+	return p.uri
+}
+
+func (p aesProfile) UserKey(passphraseHash []byte) ([]byte, error) {
+	return p.userKey(passphraseHash), nil
+}
+
+func (p aesProfile) ContentKeyEncrypter() crypto.Encrypter {
+	return crypto.NewAESEncrypter_CONTENT_KEY()
+}
+
+func (p aesProfile) FieldsEncrypter() crypto.Encrypter {
+	return crypto.NewAESEncrypter_FIELDS()
+}
+
+func (p aesProfile) KeyCheckEncrypter() crypto.Encrypter {
+	return crypto.NewAESEncrypter_USER_KEY_CHECK()
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = map[string]Profile{
+		// the user key of the basic profile is the passphrase hash
+		BasicProfileName: aesProfile{uri: BasicProfile.String(), userKey: func(hash []byte) []byte { return hash }},
+		// the user key transform of the 1.0 profile is provided by GenerateUserKey
+		V1ProfileName: aesProfile{uri: V1Profile.String(), userKey: func(hash []byte) []byte {
+			return GenerateUserKey(UserKey{Value: hash})
+		}},
+	}
+)
+
+// RegisterProfile registers a license profile under a name, replacing a profile previously registered with this name.
+// Production profiles are registered this way, typically from the init function of a package.
+// As a license only carries the profile URI, it panics if another profile is registered with the same URI.
+func RegisterProfile(name string, p Profile) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	for n, registered := range profiles {
+		if n != name && registered.URI() == p.URI() {
+			panic("license: profile " + name + " has the uri of profile " + n + ": " + p.URI())
+		}
+	}
+	profiles[name] = p
+}
+
+// GetProfile returns the license profile registered with a name
+func GetProfile(name string) (Profile, error) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	p, ok := profiles[name]
+	if !ok {
+		return nil, ErrUnknownProfile
+	}
+	return p, nil
+}
+
+// ProfileName returns the name under which a license profile is registered
+func ProfileName(p Profile) (string, error) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	for name, registered := range profiles {
+		if registered.URI() == p.URI() {
+			return name, nil
+		}
+	}
+	return "", ErrUnknownProfile
+}
+
+// ProfileNames returns the names of the registered profiles, sorted
+func ProfileNames() []string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileForURI returns the registered profile with a given URI; the fragment of the URI,
+// which indicates the encryption algorithm of the resources, is ignored.
+func profileForURI(uri string) (Profile, error) {
+	if i := strings.Index(uri, "#"); i >= 0 {
+		uri = uri[:i]
+	}
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	for _, p := range profiles {
+		if p.URI() == uri {
+			return p, nil
+		}
+	}
+	return nil, ErrUnknownProfile
+}
+
+// DefaultProfile returns the profile selected in the configuration, the basic profile by default
+func DefaultProfile() Profile {
+	if config.Config.Profile != "" {
+		if p, err := GetProfile(config.Config.Profile); err == nil {
+			return p
+		}
+	}
+	p, _ := GetProfile(BasicProfileName)
+	return p
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/index"
+)
+
+// basic profile test vectors: the passphrase is "test", the user key is its SHA-256 hash
+const (
+	vectorPassphraseHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	vectorLicenseID      = "ef15e740-697f-11e3-949a-0800200c9a66"
+	vectorKeyCheck       = "x1NwICEafo0/wYmYHq/x/oS0fmN5YLQB4s7+YRdVgb74P96eNrL0jp4BHYsppLZ7a3AlMS1Vx/LTKPLUArZRyA=="
+	vectorContentKey     = "ycmI2kjTUpWFSGRrAOPdJ5jVczmPUg2eBqJLtOh0oZUYQMa+z0sP2GYFpYkosshQK2psxMWORV2Qcl3JWOPvaQ=="
+)
+
+func decryptCBC(t *testing.T, key, in []byte) []byte {
+	var out bytes.Buffer
+	if err := crypto.NewAESCBCEncrypter().(crypto.Decrypter).Decrypt(key, bytes.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestBasicProfileVectors(t *testing.T) {
+	p, err := GetProfile(BasicProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.URI() != "http://readium.org/lcp/basic-profile" {
+		t.Errorf("Unexpected profile uri %s", p.URI())
+	}
+	hash, _ := hex.DecodeString(vectorPassphraseHash)
+	userKey, err := p.UserKey(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(userKey, hash) {
+		t.Errorf("Expected the passphrase hash as user key, got %x", userKey)
+	}
+
+	keyCheck, _ := base64.StdEncoding.DecodeString(vectorKeyCheck)
+	if id := decryptCBC(t, userKey, keyCheck); string(id) != vectorLicenseID {
+		t.Errorf("Expected key check %s, got %s", vectorLicenseID, id)
+	}
+	contentKey, _ := base64.StdEncoding.DecodeString(vectorContentKey)
+	if key := decryptCBC(t, userKey, contentKey); !bytes.Equal(key, bytes.Repeat([]byte{7}, 32)) {
+		t.Errorf("Unexpected content key %x", key)
+	}
+
+	// a license built with the basic profile can be decrypted with the user key
+	l := License{ID: vectorLicenseID}
+	c := index.Content{EncryptionKey: bytes.Repeat([]byte{7}, 32)}
+	SetLicenseProfileWith(&l, p)
+	l.Encryption.UserKey.Value = hash
+	if err = EncryptLicenseFields(&l, c); err != nil {
+		t.Fatal(err)
+	}
+	if l.Encryption.Profile != BasicProfile.String() {
+		t.Errorf("Expected '%s', got %s", BasicProfile, l.Encryption.Profile)
+	}
+	if l.Encryption.UserKey.Value != nil {
+		t.Error("The passphrase hash should be removed from the license")
+	}
+	if l.Encryption.ResourceAlgorithm != "" {
+		t.Errorf("Expected no resource algorithm for CBC, got %s", l.Encryption.ResourceAlgorithm)
+	}
+	if id := decryptCBC(t, hash, l.Encryption.UserKey.Check); string(id) != vectorLicenseID {
+		t.Errorf("Expected key check %s, got %s", vectorLicenseID, id)
+	}
+	if key := decryptCBC(t, hash, l.Encryption.ContentKey.Value); !bytes.Equal(key, c.EncryptionKey) {
+		t.Errorf("Unexpected content key %x", key)
+	}
+}
+
+// xorProfile derives the user key by a xor of the passphrase hash
+type xorProfile struct {
+	aesProfile
+}
+
+func (p xorProfile) UserKey(hash []byte) ([]byte, error) {
+	key := make([]byte, len(hash))
+	for i := range hash {
+		key[i] = hash[i] ^ 0x5a
+	}
+	return key, nil
+}
+
+func TestRegisterProfile(t *testing.T) {
+	RegisterProfile("test", xorProfile{aesProfile{uri: "http://example.com/lcp/test-profile"}})
+	p, err := GetProfile("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, _ := hex.DecodeString(vectorPassphraseHash)
+	l := License{ID: vectorLicenseID}
+	c := index.Content{EncryptionKey: bytes.Repeat([]byte{7}, 32), EncryptionAlgorithm: crypto.NewAESGCMEncrypter().Signature()}
+	SetLicenseProfileWith(&l, p)
+	// the profile uri does not depend on the algorithm of the resources
+	if expected := "http://example.com/lcp/test-profile"; l.Encryption.Profile != expected {
+		t.Errorf("Expected '%s', got %s", expected, l.Encryption.Profile)
+	}
+	l.Encryption.UserKey.Value = hash
+	if err = EncryptLicenseFields(&l, c); err != nil {
+		t.Fatal(err)
+	}
+	userKey, _ := p.UserKey(hash)
+	if l.Encryption.ResourceAlgorithm != c.EncryptionAlgorithm {
+		t.Errorf("Expected the resource algorithm %s, got %s", c.EncryptionAlgorithm, l.Encryption.ResourceAlgorithm)
+	}
+	if id := decryptCBC(t, userKey, l.Encryption.UserKey.Check); string(id) != vectorLicenseID {
+		t.Errorf("Expected key check %s, got %s", vectorLicenseID, id)
+	}
+
+	l.Encryption.Profile = "http://example.com/lcp/unknown"
+	if err = EncryptLicenseFields(&l, c); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("Expected ErrUnknownProfile, got %v", err)
+	}
+	if _, err = GetProfile("unknown"); err != ErrUnknownProfile {
+		t.Errorf("Expected ErrUnknownProfile, got %v", err)
+	}
+	if name, err := ProfileName(p); err != nil || name != "test" {
+		t.Errorf("Expected profile name test, got %s, %v", name, err)
+	}
+	// a profile can be replaced under its name, but its uri cannot be used by another name
+	RegisterProfile("test", xorProfile{aesProfile{uri: "http://example.com/lcp/test-profile"}})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected a panic when registering a profile with the uri of another profile")
+			}
+		}()
+		RegisterProfile("other", aesProfile{uri: "http://example.com/lcp/test-profile"})
+	}()
+	if _, err = GetProfile("other"); err != ErrUnknownProfile {
+		t.Errorf("Expected ErrUnknownProfile, got %v", err)
+	}
+}
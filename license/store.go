@@ -21,15 +21,47 @@ type Store interface {
 	//List() func() (License, error)
 	List(ContentID string, page int, pageNum int) func() (LicenseReport, error)
 	ListAll(page int, pageNum int) func() (LicenseReport, error)
-	UpdateRights(l License) error
-	Update(l License) error
+	ListForUser(userID string) func() (LicenseReport, error)
+	ListForContent(contentID string) func() (LicenseReport, error)
+	UpdateRights(l License, c Change) error
+	Update(l License, c Change) error
 	UpdateLsdStatus(id string, status int32) error
+	UpdateForContent(contentID string, updated time.Time, c Change) ([]string, error)
 	Add(l License) error
 	Get(id string) (License, error)
+	TenantOf(id string) (string, error)
+	History(id string) ([]HistoryEntry, error)
+	Tenant(id string) Store
 }
 
 type sqlStore struct {
 	db *sql.DB
+	// tenant the store is restricted to, if scoped
+	tenant string
+	scoped bool
+}
+
+// Tenant returns a view of the store restricted to the licenses of a tenant.
+// The default tenant has an empty id.
+func (s *sqlStore) Tenant(id string) Store {
+	return &sqlStore{db: s.db, tenant: id, scoped: true}
+}
+
+// tenantArgs inserts the tenant in query arguments, before the n last ones, if the store is scoped
+func (s *sqlStore) tenantArgs(n int, args ...interface{}) []interface{} {
+	if !s.scoped {
+		return args
+	}
+	i := len(args) - n
+	return append(args[:i:i], append([]interface{}{s.tenant}, args[i:]...)...)
+}
+
+// tenantCond returns the sql condition restricting a query to the tenant, if the store is scoped
+func (s *sqlStore) tenantCond(op string) string {
+	if s.scoped {
+		return " " + op + " tenant=?"
+	}
+	return ""
 }
 
 // ListAll lists all licenses in ante-chronological order
@@ -38,8 +70,8 @@ type sqlStore struct {
 func (s *sqlStore) ListAll(page int, pageNum int) func() (LicenseReport, error) {
 	listLicenses, err := s.db.Query(`SELECT id, user_id, provider, issued, updated,
 	rights_print, rights_copy, rights_start, rights_end, content_fk
-	FROM license
-	ORDER BY issued desc LIMIT ? OFFSET ? `, page, pageNum*page)
+	FROM license`+s.tenantCond("WHERE")+`
+	ORDER BY issued desc LIMIT ? OFFSET ? `, s.tenantArgs(2, page, pageNum*page)...)
 	if err != nil {
 		return func() (LicenseReport, error) { return LicenseReport{}, err }
 	}
@@ -70,7 +102,7 @@ func (s *sqlStore) List(contentID string, page int, pageNum int) func() (License
 	listLicenses, err := s.db.Query(`SELECT id, user_id, provider, issued, updated,
 	rights_print, rights_copy, rights_start, rights_end, content_fk
 	FROM license
-	WHERE content_fk=? LIMIT ? OFFSET ? `, contentID, page, pageNum*page)
+	WHERE content_fk=?`+s.tenantCond("AND")+` LIMIT ? OFFSET ? `, s.tenantArgs(2, contentID, page, pageNum*page)...)
 	if err != nil {
 		return func() (LicenseReport, error) { return LicenseReport{}, err }
 	}
@@ -93,54 +125,173 @@ func (s *sqlStore) List(contentID string, page int, pageNum int) func() (License
 	}
 }
 
-// UpdateRights
+// ListForUser lists all licenses of a given user, in chronological order
 //
-func (s *sqlStore) UpdateRights(l License) error {
-	result, err := s.db.Exec("UPDATE license SET rights_print=?, rights_copy=?, rights_start=?, rights_end=?,updated=?  WHERE id=?",
-		l.Rights.Print, l.Rights.Copy, l.Rights.Start, l.Rights.End, time.Now().UTC().Truncate(time.Second), l.ID)
+func (s *sqlStore) ListForUser(userID string) func() (LicenseReport, error) {
+	listLicenses, err := s.db.Query(`SELECT id, user_id, provider, issued, updated,
+	rights_print, rights_copy, rights_start, rights_end, content_fk
+	FROM license
+	WHERE user_id=?`+s.tenantCond("AND")+` ORDER BY issued`, s.tenantArgs(0, userID)...)
+	if err != nil {
+		return func() (LicenseReport, error) { return LicenseReport{}, err }
+	}
+	return func() (LicenseReport, error) {
+		var l LicenseReport
+		l.User = UserInfo{}
+		l.Rights = new(UserRights)
+		if listLicenses.Next() {
+			err := listLicenses.Scan(&l.ID, &l.User.ID, &l.Provider, &l.Issued, &l.Updated,
+				&l.Rights.Print, &l.Rights.Copy, &l.Rights.Start, &l.Rights.End, &l.ContentID)
+			if err != nil {
+				// the caller stops listing on an error
+				listLicenses.Close()
+				return l, err
+			}
+		} else {
+			listLicenses.Close()
+			err = listLicenses.Err()
+			if err == nil {
+				err = ErrNotFound
+			}
+		}
+		return l, err
+	}
+}
 
-	if err == nil {
-		if r, _ := result.RowsAffected(); r == 0 {
-			return ErrNotFound
+// ListForContent lists all licenses of a given content, in chronological order
+//
+func (s *sqlStore) ListForContent(contentID string) func() (LicenseReport, error) {
+	listLicenses, err := s.db.Query(`SELECT id, user_id, provider, issued, updated,
+	rights_print, rights_copy, rights_start, rights_end, content_fk
+	FROM license
+	WHERE content_fk=?`+s.tenantCond("AND")+` ORDER BY issued`, s.tenantArgs(0, contentID)...)
+	if err != nil {
+		return func() (LicenseReport, error) { return LicenseReport{}, err }
+	}
+	return func() (LicenseReport, error) {
+		var l LicenseReport
+		l.User = UserInfo{}
+		l.Rights = new(UserRights)
+		if listLicenses.Next() {
+			err := listLicenses.Scan(&l.ID, &l.User.ID, &l.Provider, &l.Issued, &l.Updated,
+				&l.Rights.Print, &l.Rights.Copy, &l.Rights.Start, &l.Rights.End, &l.ContentID)
+			if err != nil {
+				// the caller stops listing on an error
+				listLicenses.Close()
+				return l, err
+			}
+		} else {
+			listLicenses.Close()
+			err = listLicenses.Err()
+			if err == nil {
+				err = ErrNotFound
+			}
 		}
+		return l, err
 	}
-	return err
+}
+
+// UpdateRights updates the rights of a license and records the change in the license history
+//
+func (s *sqlStore) UpdateRights(l License, c Change) error {
+	updated := time.Now().UTC().Truncate(time.Second)
+	return s.updateWithHistory(l.ID, l.Rights, c, updated, func(tx *sql.Tx, updated time.Time) (sql.Result, error) {
+		return tx.Exec("UPDATE license SET rights_print=?, rights_copy=?, rights_start=?, rights_end=?,updated=?  WHERE id=?"+s.tenantCond("AND"),
+			s.tenantArgs(0, l.Rights.Print, l.Rights.Copy, l.Rights.Start, l.Rights.End, updated, l.ID)...)
+	})
 }
 
 // Add creates a new record in the license table
 //
 func (s *sqlStore) Add(l License) error {
-	_, err := s.db.Exec(`INSERT INTO license (id, user_id, provider, issued, updated,
-	rights_print, rights_copy, rights_start, rights_end, content_fk) 
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?,  ?, ?)`,
+	extensions, err := l.User.storedExtensions()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO license (id, user_id, provider, issued, updated,
+	rights_print, rights_copy, rights_start, rights_end, content_fk, user_extensions, profile, tenant) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?,  ?, ?, ?, ?, ?)`,
 		l.ID, l.User.ID, l.Provider, l.Issued, nil,
 		l.Rights.Print, l.Rights.Copy, l.Rights.Start, l.Rights.End,
-		l.ContentID)
+		l.ContentID, extensions, l.ProfileName, s.tenant)
 	return err
 }
 
-// Update updates a record in the license table
+// Update updates a record in the license table and records the change in the license history.
+// The update date is the one set in the license by the caller, so that it matches the date of a signed license,
+// or the current date if it is not set.
 //
-func (s *sqlStore) Update(l License) error {
-	_, err := s.db.Exec(`UPDATE license SET user_id=?,provider=?,updated=?,
-				rights_print=?,	rights_copy=?,	rights_start=?,	rights_end=?, content_fk =?
-				WHERE id=?`,
-		l.User.ID, l.Provider,
-		time.Now().UTC().Truncate(time.Second),
-		l.Rights.Print, l.Rights.Copy, l.Rights.Start, l.Rights.End,
-		l.ContentID,
-		l.ID)
+func (s *sqlStore) Update(l License, c Change) error {
+	extensions, err := l.User.storedExtensions()
+	if err != nil {
+		return err
+	}
+	updated := time.Now().UTC().Truncate(time.Second)
+	if l.Updated != nil {
+		updated = l.Updated.UTC()
+	}
+	return s.updateWithHistory(l.ID, l.Rights, c, updated, func(tx *sql.Tx, updated time.Time) (sql.Result, error) {
+		return tx.Exec(`UPDATE license SET user_id=?,provider=?,updated=?,
+				rights_print=?,	rights_copy=?,	rights_start=?,	rights_end=?, content_fk =?, user_extensions=?, profile=?
+				WHERE id=?`+s.tenantCond("AND"),
+			s.tenantArgs(0, l.User.ID, l.Provider,
+				updated,
+				l.Rights.Print, l.Rights.Copy, l.Rights.Start, l.Rights.End,
+				l.ContentID, extensions, l.ProfileName,
+				l.ID)...)
+	})
+}
 
-	return err
+// UpdateForContent sets the update date of every license of a content, in a single statement.
+// The rights of the licenses do not change, so no history is recorded.
+// It returns the ids of the updated licenses.
+//
+func (s *sqlStore) UpdateForContent(contentID string, updated time.Time, c Change) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, rights_print, rights_copy, rights_start, rights_end FROM license
+	WHERE content_fk=?`+s.tenantCond("AND"), s.tenantArgs(0, contentID)...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	var rights []*UserRights
+	for rows.Next() {
+		var id string
+		r := new(UserRights)
+		if err = rows.Scan(&id, &r.Print, &r.Copy, &r.Start, &r.End); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		rights = append(rights, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE license SET updated=? WHERE content_fk=?"+s.tenantCond("AND"), s.tenantArgs(0, updated, contentID)...)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if err = insertHistory(tx, id, updated, c, rights[i], rights[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
 }
 
 // UpdateLsdStatus
 //
 func (s *sqlStore) UpdateLsdStatus(id string, status int32) error {
 	_, err := s.db.Exec(`UPDATE license SET lsd_status =?
-				WHERE id=?`,
-		status,
-		id)
+				WHERE id=?`+s.tenantCond("AND"),
+		s.tenantArgs(0, status, id)...)
 
 	return err
 }
@@ -152,13 +303,14 @@ func (s *sqlStore) Get(id string) (License, error) {
 	var l License
 	l.Rights = new(UserRights)
 
+	var extensions *string
 	row := s.db.QueryRow(`SELECT id, user_id, provider, issued, updated, rights_print, rights_copy,
-	rights_start, rights_end, content_fk FROM license
-	where id = ?`, id)
+	rights_start, rights_end, content_fk, user_extensions, profile FROM license
+	where id = ?`+s.tenantCond("AND"), s.tenantArgs(0, id)...)
 
 	err := row.Scan(&l.ID, &l.User.ID, &l.Provider, &l.Issued, &l.Updated,
 		&l.Rights.Print, &l.Rights.Copy, &l.Rights.Start, &l.Rights.End,
-		&l.ContentID)
+		&l.ContentID, &extensions, &l.ProfileName)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -168,7 +320,20 @@ func (s *sqlStore) Get(id string) (License, error) {
 		}
 	}
 
-	return l, nil
+	// set the extension user properties
+	err = l.User.loadExtensions(extensions)
+	return l, err
+}
+
+// TenantOf returns the tenant of a visible license
+//
+func (s *sqlStore) TenantOf(id string) (string, error) {
+	var tenant string
+	err := s.db.QueryRow("SELECT tenant FROM license WHERE id=?"+s.tenantCond("AND"), s.tenantArgs(0, id)...).Scan(&tenant)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return tenant, err
 }
 
 // NewSqlStore
@@ -181,8 +346,17 @@ func NewSqlStore(db *sql.DB) (Store, error) {
 			log.Println("Error creating sqlite license table")
 			return nil, err
 		}
+		_, err = db.Exec(historyTableDef)
+		if err != nil {
+			log.Println("Error creating sqlite license history table")
+			return nil, err
+		}
+		// add columns to a table created by a previous version
+		db.Exec("ALTER TABLE license ADD COLUMN user_extensions text DEFAULT NULL")
+		db.Exec("ALTER TABLE license ADD COLUMN tenant varchar(255) NOT NULL DEFAULT ''")
+		db.Exec("ALTER TABLE license ADD COLUMN profile varchar(255) NOT NULL DEFAULT ''")
 	}
-	return &sqlStore{db}, nil
+	return &sqlStore{db: db}, nil
 }
 
 const tableDef = "CREATE TABLE IF NOT EXISTS license (" +
@@ -197,4 +371,7 @@ const tableDef = "CREATE TABLE IF NOT EXISTS license (" +
 	"rights_end datetime DEFAULT NULL," +
 	"content_fk varchar(255) NOT NULL," +
 	"lsd_status integer default 0," +
+	"user_extensions text DEFAULT NULL," +
+	"tenant varchar(255) NOT NULL DEFAULT ''," +
+	"profile varchar(255) NOT NULL DEFAULT ''," +
 	"FOREIGN KEY(content_fk) REFERENCES content(id))"
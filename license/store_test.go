@@ -68,7 +68,7 @@ func TestStoreAdd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	l := License{}
+	l := License{ProfileName: V1ProfileName}
 	contentID := "1234-1234-1234-1234"
 	Initialize(contentID, &l)
 	setRights(&l)
@@ -87,6 +87,10 @@ func TestStoreAdd(t *testing.T) {
 	if err != nil || err2 != nil || !bytes.Equal(js1, js2) {
 		t.Error("Difference between Add and Get")
 	}
+	// the profile name is not serialized
+	if l2.ProfileName != V1ProfileName {
+		t.Errorf("Expected profile %s, got %s", V1ProfileName, l2.ProfileName)
+	}
 }
 
 // a rights object is needed before adding a record to the db
@@ -106,3 +110,192 @@ func setRights(lic *License) {
 		lic.Rights.End = &end
 	}
 }
+
+func TestTenantStore(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	st, err := NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA, tenantB := st.Tenant("a"), st.Tenant("b")
+
+	l := License{User: UserInfo{ID: "user"}}
+	Initialize("content", &l)
+	setRights(&l)
+	if err = tenantA.Add(l); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tenantA.Get(l.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err = tenantB.Get(l.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = st.Tenant("").Get(l.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	// the unscoped store sees every license
+	if _, err = st.Get(l.ID); err != nil {
+		t.Error(err)
+	}
+	if tenant, err := st.TenantOf(l.ID); err != nil || tenant != "a" {
+		t.Errorf("Expected tenant a, got %s (%v)", tenant, err)
+	}
+	if _, err = tenantB.TenantOf(l.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	count := func(fn func() (LicenseReport, error)) int {
+		n := 0
+		for _, err := fn(); err == nil; _, err = fn() {
+			n++
+		}
+		return n
+	}
+	if n := count(tenantA.ListAll(10, 0)); n != 1 {
+		t.Errorf("Expected 1 license, got %d", n)
+	}
+	if n := count(tenantB.ListAll(10, 0)); n != 0 {
+		t.Errorf("Expected no license, got %d", n)
+	}
+	if n := count(tenantB.List("content", 10, 0)); n != 0 {
+		t.Errorf("Expected no license, got %d", n)
+	}
+	if n := count(tenantB.ListForUser("user")); n != 0 {
+		t.Errorf("Expected no license, got %d", n)
+	}
+	if n := count(tenantA.ListForUser("user")); n != 1 {
+		t.Errorf("Expected 1 license, got %d", n)
+	}
+	if err = tenantB.UpdateRights(l, Change{}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	st, err := NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA, tenantB := st.Tenant("a"), st.Tenant("b")
+
+	l := License{User: UserInfo{ID: "user"}}
+	Initialize("content", &l)
+	setRights(&l)
+	end := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.Rights.End = &end
+	if err = tenantA.Add(l); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := tenantA.History(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no history entry, got %d", len(entries))
+	}
+
+	renewed := end.AddDate(0, 1, 0)
+	l.Rights.End = &renewed
+	if err = tenantA.UpdateRights(l, Change{Caller: "lsd", Origin: OriginLsdRenew}); err != nil {
+		t.Fatal(err)
+	}
+	nPrint := int32(10)
+	l.Rights.Print = &nPrint
+	if err = tenantA.Update(l, Change{Caller: "admin", Origin: OriginPatch}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = tenantA.History(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 history entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Caller != "lsd" || e.Origin != OriginLsdRenew || !e.PreviousRights.End.Equal(end) || !e.NewRights.End.Equal(renewed) {
+		t.Errorf("Unexpected first history entry %+v", e)
+	}
+	if e := entries[1]; e.Caller != "admin" || e.Origin != OriginPatch || e.PreviousRights.Print != nil || *e.NewRights.Print != nPrint {
+		t.Errorf("Unexpected second history entry %+v", e)
+	}
+
+	// the history of a license is not visible to other tenants
+	if _, err = tenantB.History(l.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = tenantA.History("unknown"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreListForContent(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	st, err := NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA, tenantB := st.Tenant("a"), st.Tenant("b")
+
+	for _, content := range []string{"content", "content", "other"} {
+		l := License{User: UserInfo{ID: "user"}}
+		Initialize(content, &l)
+		setRights(&l)
+		if err = tenantA.Add(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(fn func() (LicenseReport, error)) int {
+		n := 0
+		for _, err := fn(); err == nil; _, err = fn() {
+			n++
+		}
+		return n
+	}
+	if n := count(tenantA.ListForContent("content")); n != 2 {
+		t.Errorf("Expected 2 licenses, got %d", n)
+	}
+	// a tenant cannot list the licenses of another tenant
+	if n := count(tenantB.ListForContent("content")); n != 0 {
+		t.Errorf("Expected no license, got %d", n)
+	}
+	// the rows are closed at the end of the list, the connection can be reused
+	if n := count(tenantA.ListForContent("other")); n != 1 {
+		t.Errorf("Expected 1 license, got %d", n)
+	}
+
+	// the licenses of a content are updated at once, in the tenant only
+	updated := time.Now().UTC().Truncate(time.Second)
+	if ids, err := tenantB.UpdateForContent("content", updated, Change{Origin: OriginContentVersion}); err != nil || len(ids) != 0 {
+		t.Errorf("Expected no updated license, got %v, %v", ids, err)
+	}
+	ids, err := tenantA.UpdateForContent("content", updated, Change{Origin: OriginContentVersion})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("Expected 2 updated licenses, got %v, %v", ids, err)
+	}
+	for _, id := range ids {
+		if l, err := tenantA.Get(id); err != nil || l.Updated == nil || !l.Updated.Equal(updated) {
+			t.Errorf("Expected license %s updated at %s, got %v (%v)", id, updated, l.Updated, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidEncryptedField is returned when a field marked as encrypted is not a string or is unknown
+var ErrInvalidEncryptedField = errors.New("invalid encrypted user field")
+
+// standard user properties, which cannot be used as extensions
+var userProperties = map[string]bool{"id": true, "email": true, "name": true, "encrypted": true}
+
+// userExtensions is the representation of extension user properties in the db
+type userExtensions struct {
+	Values    map[string]interface{} `json:"values"`
+	Encrypted []string               `json:"encrypted,omitempty"`
+}
+
+// MarshalJSON serializes the user info, extension properties being inlined in the user object
+func (u UserInfo) MarshalJSON() ([]byte, error) {
+
+	type userInfo UserInfo
+	b, err := json.Marshal(userInfo(u))
+	if err != nil || len(u.Extensions) == 0 {
+		return b, err
+	}
+	var props map[string]interface{}
+	if err = json.Unmarshal(b, &props); err != nil {
+		return nil, err
+	}
+	for name, value := range u.Extensions {
+		if userProperties[name] {
+			return nil, fmt.Errorf("reserved user property %s used as an extension", name)
+		}
+		props[name] = value
+	}
+	return json.Marshal(props)
+}
+
+// UnmarshalJSON deserializes the user info, unknown properties being user extensions
+func (u *UserInfo) UnmarshalJSON(data []byte) error {
+
+	type userInfo UserInfo
+	var ui userInfo
+	if err := json.Unmarshal(data, &ui); err != nil {
+		return err
+	}
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal(data, &props); err != nil {
+		return err
+	}
+	ui.Extensions = nil
+	for name, raw := range props {
+		if userProperties[name] {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		if ui.Extensions == nil {
+			ui.Extensions = make(map[string]interface{})
+		}
+		ui.Extensions[name] = value
+	}
+	*u = UserInfo(ui)
+	return nil
+}
+
+// MergeExtensions sets the extension properties of the user from a partial user info:
+// properties set in the input take precedence over current ones,
+// and a property stays encrypted once marked as encrypted.
+func (u *UserInfo) MergeExtensions(in UserInfo) {
+
+	for name, value := range in.Extensions {
+		if u.Extensions == nil {
+			u.Extensions = make(map[string]interface{})
+		}
+		u.Extensions[name] = value
+	}
+	for _, name := range in.Encrypted {
+		if !contains(u.Encrypted, name) {
+			u.Encrypted = append(u.Encrypted, name)
+		}
+	}
+}
+
+// storedExtensions returns the clear extension properties and the names of the encrypted ones, as a json string.
+// nil is returned if the user has no extension.
+func (u *UserInfo) storedExtensions() (*string, error) {
+
+	values := u.Extensions
+	if u.clearExtensions != nil {
+		values = u.clearExtensions
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	ext := userExtensions{Values: values}
+	for _, name := range u.Encrypted {
+		if _, ok := values[name]; ok {
+			ext.Encrypted = append(ext.Encrypted, name)
+		}
+	}
+	b, err := json.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// loadExtensions sets the extension properties of the user from their representation in the db
+func (u *UserInfo) loadExtensions(stored *string) error {
+
+	if stored == nil || *stored == "" {
+		return nil
+	}
+	var ext userExtensions
+	if err := json.Unmarshal([]byte(*stored), &ext); err != nil {
+		return err
+	}
+	u.Extensions = ext.Values
+	u.Encrypted = ext.Encrypted
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
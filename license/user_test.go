@@ -0,0 +1,116 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package license
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/crypto"
+	"github.com/readium/readium-lcp-server/index"
+)
+
+func TestUserExtensionsJSON(t *testing.T) {
+	in := `{"id":"u1","email":"a@b.c","card_number":"1234","age":42,"encrypted":["email","card_number"]}`
+	var u UserInfo
+	if err := json.Unmarshal([]byte(in), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "u1" || u.Email != "a@b.c" || len(u.Encrypted) != 2 {
+		t.Errorf("Unexpected standard properties: %+v", u)
+	}
+	if u.Extensions["card_number"] != "1234" || u.Extensions["age"] != float64(42) || len(u.Extensions) != 2 {
+		t.Errorf("Unexpected extensions: %v", u.Extensions)
+	}
+
+	out, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var props map[string]interface{}
+	json.Unmarshal(out, &props)
+	if props["card_number"] != "1234" || props["id"] != "u1" || len(props) != 5 {
+		t.Errorf("Unexpected serialization: %s", out)
+	}
+
+	// a user without extension is serialized as before
+	out, _ = json.Marshal(UserInfo{ID: "u2"})
+	if string(out) != `{"id":"u2"}` {
+		t.Errorf("Unexpected serialization: %s", out)
+	}
+}
+
+func TestEncryptUserExtensions(t *testing.T) {
+	config.Config.LcpServer.Database = "sqlite"
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := NewSqlStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userKey := bytes.Repeat([]byte{1}, 32)
+	l := License{User: UserInfo{
+		ID:         "u1",
+		Email:      "a@b.c",
+		Encrypted:  []string{"email", "card_number"},
+		Extensions: map[string]interface{}{"card_number": "1234", "branch": "north"},
+	}}
+	Initialize("content", &l)
+	setRights(&l)
+	l.Encryption.UserKey.Value = userKey
+	if err = EncryptLicenseFields(&l, index.Content{EncryptionKey: bytes.Repeat([]byte{2}, 32)}); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypt := func(value interface{}) string {
+		s, _ := value.(string)
+		in, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err = crypto.NewAESEncrypter_FIELDS().(crypto.Decrypter).Decrypt(userKey, bytes.NewReader(in), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if decrypt(l.User.Extensions["card_number"]) != "1234" || decrypt(l.User.Email) != "a@b.c" {
+		t.Error("Unexpected encrypted user fields")
+	}
+	if l.User.Extensions["branch"] != "north" {
+		t.Errorf("Expected a clear extension, got %v", l.User.Extensions["branch"])
+	}
+
+	// the clear values are stored, with the names of the encrypted extensions
+	if err = st.Add(l); err != nil {
+		t.Fatal(err)
+	}
+	l2, err := st.Get(l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l2.User.Extensions["card_number"] != "1234" || l2.User.Extensions["branch"] != "north" {
+		t.Errorf("Unexpected stored extensions: %v", l2.User.Extensions)
+	}
+	if len(l2.User.Encrypted) != 1 || l2.User.Encrypted[0] != "card_number" {
+		t.Errorf("Unexpected stored encrypted extensions: %v", l2.User.Encrypted)
+	}
+
+	// unknown and non-string fields cannot be encrypted
+	for _, name := range []string{"unknown", "age"} {
+		l3 := License{User: UserInfo{ID: "u1", Encrypted: []string{name}, Extensions: map[string]interface{}{"age": 42.0}}}
+		l3.Encryption.UserKey.Value = userKey
+		if err = EncryptLicenseFields(&l3, index.Content{EncryptionKey: userKey}); err == nil {
+			t.Errorf("Expected an error encrypting %s", name)
+		}
+	}
+}
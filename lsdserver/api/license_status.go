@@ -5,10 +5,10 @@
 package apilsd
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
@@ -25,6 +25,7 @@ import (
 	"github.com/readium/readium-lcp-server/localization"
 	"github.com/readium/readium-lcp-server/logging"
 	"github.com/readium/readium-lcp-server/problem"
+	"github.com/readium/readium-lcp-server/schema"
 	"github.com/readium/readium-lcp-server/status"
 	"github.com/readium/readium-lcp-server/transactions"
 )
@@ -43,12 +44,23 @@ func CreateLicenseStatusDocument(w http.ResponseWriter, r *http.Request, s Serve
 	err := apilcp.DecodeJSONLicense(r, &lic)
 
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		problem.BadRequest(w, r, err)
 		return
 	}
 
+	// the end of the potential rights may be set by the license policy
+	var potentialEnd *time.Time
+	if pre := r.URL.Query().Get("potential_rights_end"); pre != "" {
+		end, err := time.Parse(time.RFC3339, pre)
+		if err != nil {
+			problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		potentialEnd = &end
+	}
+
 	var ls licensestatuses.LicenseStatus
-	makeLicenseStatus(lic, &ls)
+	makeLicenseStatus(lic, potentialEnd, &ls)
 
 	err = s.LicenseStatuses().Add(ls)
 	if err != nil {
@@ -60,6 +72,107 @@ func CreateLicenseStatusDocument(w http.ResponseWriter, r *http.Request, s Serve
 	w.WriteHeader(http.StatusCreated)
 }
 
+// CreateLicenseStatusDocuments creates license status documents in bulk.
+// The input body is a json array of license notifications, the output body a json array of per-license results.
+// Each notification is validated separately, so that an erroneous item does not fail the whole request.
+func CreateLicenseStatusDocuments(w http.ResponseWriter, r *http.Request, s Server) {
+	var rawItems []json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&rawItems)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]apilcp.LsdNotificationResult, len(rawItems))
+	for i, raw := range rawItems {
+		var n apilcp.LsdNotification
+		if err = schema.LsdNotification.Validate(raw); err == nil {
+			err = json.Unmarshal(raw, &n)
+		}
+		if err == nil && n.License.ID == "" {
+			err = schema.ValidationError{{Pointer: "/license/id", Reason: "is required"}}
+		}
+		results[i].ID = n.License.ID
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Problem = notificationProblem(err)
+			continue
+		}
+		var ls licensestatuses.LicenseStatus
+		makeLicenseStatus(n.License, n.PotentialRightsEnd, &ls)
+		if err = s.LicenseStatuses().Add(ls); err != nil {
+			log.Println("Error creating the status of license " + n.License.ID + ": " + err.Error())
+			results[i].Status = http.StatusInternalServerError
+		} else {
+			results[i].Status = http.StatusCreated
+		}
+	}
+
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+// NotifyLicensesUpdated sets the update date of licenses updated by the License Server,
+// e.g. after a passphrase change, so that reading systems fetch a fresh license.
+// The input body is a json array of license updates, the output body a json array of per-license results,
+// so that the License Server can retry the failed updates.
+// Each update is validated separately, so that an erroneous item does not fail the whole request.
+func NotifyLicensesUpdated(w http.ResponseWriter, r *http.Request, s Server) {
+	var rawItems []json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&rawItems)
+	if err != nil {
+		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	currentTime := time.Now().UTC().Truncate(time.Second)
+	results := make([]apilcp.LsdNotificationResult, len(rawItems))
+	for i, raw := range rawItems {
+		var u apilcp.LicenseUpdate
+		if err = schema.LicenseUpdate.Validate(raw); err == nil {
+			err = json.Unmarshal(raw, &u)
+		}
+		results[i].ID = u.ID
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Problem = notificationProblem(err)
+			continue
+		}
+		licenseStatus, err := s.LicenseStatuses().GetByLicenseID(u.ID)
+		if err != nil {
+			log.Println("Error getting the status of license " + u.ID + ": " + err.Error())
+			results[i].Status = http.StatusInternalServerError
+			if licenseStatus == nil {
+				results[i].Status = http.StatusNotFound
+			}
+			continue
+		}
+		updated := u.Updated.UTC()
+		licenseStatus.Updated.License = &updated
+		licenseStatus.Updated.Status = &currentTime
+		if err = s.LicenseStatuses().Update(*licenseStatus); err != nil {
+			log.Println("Error updating the status of license " + u.ID + ": " + err.Error())
+			results[i].Status = http.StatusInternalServerError
+			continue
+		}
+		results[i].Status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", api.ContentType_JSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+// notificationProblem reports an invalid item of a notification, listing its invalid values if the error provides them
+func notificationProblem(err error) *problem.Problem {
+	p := &problem.Problem{Title: http.StatusText(http.StatusBadRequest), Status: http.StatusBadRequest, Detail: err.Error()}
+	if e, ok := err.(problem.InvalidParamsError); ok {
+		p.InvalidParams = e.InvalidParams()
+	}
+	return p
+}
+
 // GetLicenseStatusDocument gets a license status from the db by license id
 // checks potential_rights_end and fill it
 func GetLicenseStatusDocument(w http.ResponseWriter, r *http.Request, s Server) {
@@ -307,7 +420,7 @@ func LendingReturn(w http.ResponseWriter, r *http.Request, s Server) {
 
 	// update a license via a call to the lcp Server
 	// the event date is sent to the lcp server, covers the case where the lsd server clock is badly sync'd with the lcp server clock
-	httpStatusCode, errorr := updateLicense(event.Timestamp, licenseID)
+	httpStatusCode, errorr := updateLicense(event.Timestamp, licenseID, license.OriginLsdReturn)
 	if errorr != nil {
 		problem.Error(w, r, problem.Problem{Detail: errorr.Error()}, http.StatusInternalServerError)
 		logging.WriteToFile(complianceTestNumber, RETURN_LICENSE, strconv.Itoa(http.StatusInternalServerError), err.Error())
@@ -471,7 +584,7 @@ func LendingRenewal(w http.ResponseWriter, r *http.Request, s Server) {
 	}
 
 	// update a license via a call to the lcp Server
-	httpStatusCode, errorr := updateLicense(suggestedEnd, licenseID)
+	httpStatusCode, errorr := updateLicense(suggestedEnd, licenseID, license.OriginLsdRenew)
 	if errorr != nil {
 		problem.Error(w, r, problem.Problem{Detail: errorr.Error()}, http.StatusInternalServerError)
 		logging.WriteToFile(complianceTestNumber, RENEW_LICENSE, strconv.Itoa(http.StatusInternalServerError), errorr.Error())
@@ -671,8 +784,8 @@ func LendingCancellation(w http.ResponseWriter, r *http.Request, s Server) {
 	var newStatus licensestatuses.LicenseStatus
 	err = decodeJsonLicenseStatus(r, &newStatus)
 	if err != nil {
-		problem.Error(w, r, problem.Problem{Detail: err.Error()}, http.StatusInternalServerError)
-		logging.WriteToFile(complianceTestNumber, CANCEL_REVOKE_LICENSE, strconv.Itoa(http.StatusInternalServerError), err.Error())
+		problem.BadRequest(w, r, err)
+		logging.WriteToFile(complianceTestNumber, CANCEL_REVOKE_LICENSE, strconv.Itoa(http.StatusBadRequest), err.Error())
 		return
 	}
 	// the new status must be either cancelled or revoked
@@ -708,7 +821,7 @@ func LendingCancellation(w http.ResponseWriter, r *http.Request, s Server) {
 	currentTime := time.Now().UTC().Truncate(time.Second)
 
 	// update the license with the new expiration time, via a call to the lcp Server
-	httpStatusCode, erru := updateLicense(currentTime, licenseID)
+	httpStatusCode, erru := updateLicense(currentTime, licenseID, license.OriginLsdRevoke)
 	if erru != nil {
 		problem.Error(w, r, problem.Problem{Detail: erru.Error()}, http.StatusInternalServerError)
 		logging.WriteToFile(complianceTestNumber, CANCEL_REVOKE_LICENSE, strconv.Itoa(http.StatusInternalServerError), erru.Error())
@@ -758,8 +871,9 @@ func LendingCancellation(w http.ResponseWriter, r *http.Request, s Server) {
 }
 
 // makeLicenseStatus sets fields of license status according to the config file
-// and creates needed inner objects of license status
-func makeLicenseStatus(license license.License, ls *licensestatuses.LicenseStatus) {
+// and creates needed inner objects of license status.
+// If set, potentialEnd (from the license policy) takes precedence over the renting days of the config file.
+func makeLicenseStatus(license license.License, potentialEnd *time.Time, ls *licensestatuses.LicenseStatus) {
 	ls.LicenseRef = license.ID
 
 	registerAvailable := config.Config.LicenseStatus.Register
@@ -774,7 +888,14 @@ func makeLicenseStatus(license license.License, ls *licensestatuses.LicenseStatu
 		ls.PotentialRights = new(licensestatuses.PotentialRights)
 
 		rentingDays := config.Config.LicenseStatus.RentingDays
-		if rentingDays > 0 {
+		if potentialEnd != nil {
+			if endFromLicense.After(*potentialEnd) {
+				ls.PotentialRights.End = &endFromLicense
+			} else {
+				end := potentialEnd.UTC().Truncate(time.Second)
+				ls.PotentialRights.End = &end
+			}
+		} else if rentingDays > 0 {
 			endFromConfig := license.Issued.Add(time.Hour * 24 * time.Duration(rentingDays))
 
 			if endFromLicense.After(endFromConfig) {
@@ -911,25 +1032,26 @@ func makeEvent(status string, deviceName string, deviceID string, licenseStatusF
 	return &event
 }
 
-// decodeJsonLicenseStatus decodes license status json to the object
+// decodeJsonLicenseStatus decodes license status json to the object,
+// after its validation against the license status document schema
 func decodeJsonLicenseStatus(r *http.Request, ls *licensestatuses.LicenseStatus) error {
-	var dec *json.Decoder
+	var body []byte
+	var err error
 
 	if ctype := r.Header["Content-Type"]; len(ctype) > 0 && ctype[0] == api.ContentType_FORM_URL_ENCODED {
-		buf := bytes.NewBufferString(r.PostFormValue("data"))
-		dec = json.NewDecoder(buf)
-	} else {
-		dec = json.NewDecoder(r.Body)
+		body = []byte(r.PostFormValue("data"))
+	} else if body, err = ioutil.ReadAll(r.Body); err != nil {
+		return err
 	}
-
-	err := dec.Decode(&ls)
-
-	return err
+	if err = schema.LicenseStatus.Validate(body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, ls)
 }
 
 // updateLicense updates a license by calling the License Server
-// called from return, renew and cancel/revoke actions
-func updateLicense(timeEnd time.Time, licenseID string) (int, error) {
+// called from return, renew and cancel/revoke actions; the origin of the change is recorded in the license history
+func updateLicense(timeEnd time.Time, licenseID string, origin string) (int, error) {
 	// get the lcp server url
 	lcpBaseURL := config.Config.LcpServer.PublicBaseUrl
 	if len(lcpBaseURL) <= 0 {
@@ -951,7 +1073,7 @@ func updateLicense(timeEnd time.Time, licenseID string) (int, error) {
 		pw.Close()
 	}()
 	// prepare the request
-	lcpURL := lcpBaseURL + "/licenses/" + licenseID
+	lcpURL := lcpBaseURL + "/licenses/" + licenseID + "?origin=" + origin
 	// message to the console
 	log.Println("PATCH " + lcpURL)
 	// send the content to the LCP server
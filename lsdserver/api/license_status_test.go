@@ -0,0 +1,117 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package apilsd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	apilcp "github.com/readium/readium-lcp-server/lcpserver/api"
+	licensestatuses "github.com/readium/readium-lcp-server/license_statuses"
+	"github.com/readium/readium-lcp-server/transactions"
+)
+
+// testServer is a License Status Server backed by an in-memory database
+type testServer struct {
+	lst licensestatuses.LicenseStatuses
+}
+
+func (s *testServer) Transactions() transactions.Transactions          { return nil }
+func (s *testServer) LicenseStatuses() licensestatuses.LicenseStatuses { return s.lst }
+func (s *testServer) GoofyMode() bool                                  { return false }
+
+func newTestServer(t *testing.T) *testServer {
+	config.Config.LsdServer.Database = "sqlite" // FIXME
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	lst, err := licensestatuses.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{lst: lst}
+}
+
+// postNotifications calls a notification handler and decodes its per-item results
+func postNotifications(t *testing.T, handler func(http.ResponseWriter, *http.Request, Server), s Server, body string) []apilcp.LsdNotificationResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/licenses", strings.NewReader(body)), s)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var results []apilcp.LsdNotificationResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestCreateLicenseStatusDocuments(t *testing.T) {
+	s := newTestServer(t)
+	body := `[{"license": {"id": "l1", "issued": "2022-03-01T10:00:00Z", "rights": {"end": "2032-03-01T10:00:00Z"}}},
+		{"license": {"id": 2, "rights": {"print": -1}}},
+		{"license": {"provider": "p"}}]`
+	results := postNotifications(t, CreateLicenseStatusDocuments, s, body)
+	if len(results) != 3 || results[0].Status != http.StatusCreated {
+		t.Fatalf("Expected a created status first, got %+v", results)
+	}
+	if _, err := s.lst.GetByLicenseID("l1"); err != nil {
+		t.Errorf("Expected a status of l1, got %v", err)
+	}
+
+	// the invalid items are reported with their invalid values, without failing the request
+	for i, expected := range [][]string{{"/license/id", "/license/rights/print"}, {"/license/id"}} {
+		res := results[i+1]
+		if res.Status != http.StatusBadRequest || res.Problem == nil || len(res.Problem.InvalidParams) != len(expected) {
+			t.Errorf("Expected %d invalid params for item %d, got %+v", len(expected), i+1, res)
+			continue
+		}
+		for j, param := range res.Problem.InvalidParams {
+			if param.Pointer != expected[j] {
+				t.Errorf("Expected the pointer %s, got %s", expected[j], param.Pointer)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	CreateLicenseStatusDocuments(w, httptest.NewRequest("POST", "/licenses", strings.NewReader(`{}`)), s)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestNotifyLicensesUpdated(t *testing.T) {
+	s := newTestServer(t)
+	postNotifications(t, CreateLicenseStatusDocuments, s, `[{"license": {"id": "l1", "issued": "2022-03-01T10:00:00Z"}}]`)
+
+	body := `[{"id": "l1", "updated": "2022-04-01T10:00:00Z"}, {"id": "l1", "updated": "yesterday"}, {"updated": "2022-04-01T10:00:00Z"}]`
+	results := postNotifications(t, NotifyLicensesUpdated, s, body)
+	if len(results) != 3 || results[0].Status != http.StatusOK {
+		t.Fatalf("Expected an updated status first, got %+v", results)
+	}
+	for i, pointer := range []string{"/updated", "/id"} {
+		res := results[i+1]
+		if res.Status != http.StatusBadRequest || res.Problem == nil || len(res.Problem.InvalidParams) != 1 ||
+			res.Problem.InvalidParams[0].Pointer != pointer {
+			t.Errorf("Expected the invalid param %s, got %+v", pointer, res)
+		}
+	}
+	ls, err := s.lst.GetByLicenseID("l1")
+	if err != nil || ls.Updated == nil || ls.Updated.License == nil || ls.Updated.License.Format("2006-01-02") != "2022-04-01" {
+		t.Errorf("Expected the license update date to be set, got %+v (%v)", ls, err)
+	}
+}
@@ -80,6 +80,8 @@ func New(bindAddr string, readonly bool, complianceMode bool, goofyMode bool, ls
 		s.handlePrivateFunc(licenseRoutes, "/{key}/status", apilsd.LendingCancellation, basicAuth).Methods("PATCH")
 
 		s.handlePrivateFunc(sr.R, "/licenses", apilsd.CreateLicenseStatusDocument, basicAuth).Methods("PUT")
+		s.handlePrivateFunc(licenseRoutes, "/bulk", apilsd.CreateLicenseStatusDocuments, basicAuth).Methods("PUT")
+		s.handlePrivateFunc(licenseRoutes, "/updated", apilsd.NotifyLicensesUpdated, basicAuth).Methods("PUT")
 		s.handlePrivateFunc(licenseRoutes, "/", apilsd.CreateLicenseStatusDocument, basicAuth).Methods("PUT")
 	}
 
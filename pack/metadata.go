@@ -0,0 +1,89 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+// EPUBMetadata extracts the metadata of an EPUB publication from its (first) OPF package
+func EPUBMetadata(ep epub.Epub) index.Metadata {
+
+	var m index.Metadata
+	if len(ep.Package) == 0 {
+		return m
+	}
+	p := ep.Package[0]
+	m.Title = firstValue(p.Metadata.Titles)
+	for _, creator := range p.Metadata.Creators {
+		if creator = strings.TrimSpace(creator); creator != "" {
+			m.Authors = append(m.Authors, creator)
+		}
+	}
+	m.Language = firstValue(p.Metadata.Languages)
+	m.Identifier = p.Identifier()
+	m.Publisher = firstValue(p.Metadata.Publishers)
+	return m
+}
+
+// RWPMetadata extracts the metadata of a publication from its Readium Web Publication Manifest
+func RWPMetadata(rm rwpm.Metadata) index.Metadata {
+
+	var m index.Metadata
+	m.Title = localizedText(rm.Title)
+	for _, author := range rm.Author {
+		if name := localizedText(author.Name); name != "" {
+			m.Authors = append(m.Authors, name)
+		}
+	}
+	m.Language = firstValue(rm.Language)
+	m.Identifier = strings.TrimSpace(rm.Identifier)
+	var publishers []string
+	for _, publisher := range rm.Publisher {
+		if name := localizedText(publisher.Name); name != "" {
+			publishers = append(publishers, name)
+		}
+	}
+	m.Publisher = strings.Join(publishers, ", ")
+	return m
+}
+
+// Metadata returns the metadata of the Readium Package
+func (reader *RPFReader) Metadata() index.Metadata {
+	return RWPMetadata(reader.manifest.Metadata)
+}
+
+// firstValue returns the first non empty value of a list, trimmed
+func firstValue(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// localizedText returns the default value of a localized string, or the value of the first language
+func localizedText(ml rwpm.MultiLanguage) string {
+	if text := ml.Text(); text != "" {
+		return strings.TrimSpace(text)
+	}
+	languages := make([]string, 0, len(ml))
+	for language := range ml {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		if text := strings.TrimSpace(ml[language]); text != "" {
+			return text
+		}
+	}
+	return ""
+}
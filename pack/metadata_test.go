@@ -0,0 +1,62 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package pack
+
+import (
+	"archive/zip"
+	"reflect"
+	"testing"
+
+	"github.com/readium/readium-lcp-server/epub"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/rwpm"
+)
+
+func TestEPUBMetadata(t *testing.T) {
+	z, err := zip.OpenReader("../test/samples/sample.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	ep, err := epub.Read(&z.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := index.Metadata{
+		Title:      "Moby-Dick",
+		Authors:    []string{"Herman Melville"},
+		Language:   "en-US",
+		Identifier: "code.google.com.epub-samples.moby-dick-basic",
+		Publisher:  "Harper & Brothers, Publishers",
+	}
+	if m := EPUBMetadata(ep); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, m)
+	}
+}
+
+func TestRWPMetadata(t *testing.T) {
+	reader, err := OpenRPF("./samples/basic.webpub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	expected := index.Metadata{
+		Title:      "Basic sample of a manifest associated with a pdf file",
+		Authors:    []string{"Readium Community"},
+		Language:   "en",
+		Identifier: "https://readium.org/webpub-manifest/basic-sample",
+	}
+	if m := reader.Metadata(); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, m)
+	}
+
+	// localized title without default value
+	m := RWPMetadata(rwpm.Metadata{Title: rwpm.MultiLanguage{"fr": "Titre", "en": "Title"}})
+	if m.Title != "Title" {
+		t.Errorf("Expected Title, got %s", m.Title)
+	}
+}
@@ -160,3 +160,69 @@ func TestPackingWithSpace(t *testing.T) {
 	}
 
 }
+
+func TestPackingGCM(t *testing.T) {
+	z, err := zip.OpenReader("../test/samples/sample.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	input, _ := epub.Read(&z.Reader)
+
+	htmlFilePath := "OPS/chapter_001.xhtml"
+	inputRes, ok := FindFile(htmlFilePath, input)
+	if !ok {
+		t.Fatalf("Could not find %s in input", htmlFilePath)
+	}
+	inputBytes, err := ioutil.ReadAll(inputRes.Contents)
+	if err != nil {
+		t.Fatalf("Could not find %s in input", htmlFilePath)
+	}
+	inputRes.Contents = bytes.NewReader(inputBytes)
+
+	buf := new(bytes.Buffer)
+	encrypter, err := crypto.NewAESEncrypterForMode(crypto.ModeGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := Do(encrypter, "", input, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, _ := epub.Read(zr)
+
+	data, ok := output.Encryption.DataForFile(htmlFilePath)
+	if !ok {
+		t.Fatalf("Could not find %s in encryption.xml", htmlFilePath)
+	}
+	if data.Method.Algorithm != xmlenc.URI(encrypter.Signature()) {
+		t.Errorf("Expected algorithm %s, got %s", encrypter.Signature(), data.Method.Algorithm)
+	}
+
+	res, ok := FindFile(htmlFilePath, output)
+	if !ok {
+		t.Fatalf("Could not find html file")
+	}
+	// the decrypter is selected from the algorithm found in encryption.xml
+	decrypter, err := crypto.NewAESDecrypter(string(data.Method.Algorithm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clear bytes.Buffer
+	if err = decrypter.Decrypt(key, res.Contents, &clear); err != nil {
+		t.Fatal(err)
+	}
+	outputBytes, err := ioutil.ReadAll(flate.NewReader(&clear))
+	if err != nil {
+		t.Fatalf("Could not decompress data from %s", htmlFilePath)
+	}
+	if !bytes.Equal(inputBytes, outputBytes) {
+		t.Errorf("Expected the files to be equal before and after")
+	}
+}
@@ -35,11 +35,12 @@ type Task struct {
 	done chan Result
 }
 
-// EncryptedFileInfo contains a file, its size and sha256
+// EncryptedFileInfo contains a file, its size, sha256 and the encryption algorithm of its resources
 type EncryptedFileInfo struct {
-	File   *os.File
-	Size   int64
-	Sha256 string
+	File      *os.File
+	Size      int64
+	Sha256    string
+	Algorithm string
 }
 
 // NewTask generates a new task
@@ -98,7 +99,7 @@ func (p Packager) work() {
 		ep := p.readEpub(&r, zr)
 		encrypted, key := p.encrypt(&r, ep)
 		p.addToStore(&r, encrypted)
-		p.addToIndex(&r, key, t.Name, encrypted, epub.ContentType_EPUB)
+		p.addToIndex(&r, key, t.Name, encrypted, epub.ContentType_EPUB, EPUBMetadata(ep))
 
 		t.Done(r)
 	}
@@ -151,6 +152,7 @@ func (p Packager) encrypt(r *Result, ep epub.Epub) (*EncryptedFileInfo, []byte)
 	r.Error = err
 	var encryptedFileInfo EncryptedFileInfo
 	encryptedFileInfo.File = tmpFile
+	encryptedFileInfo.Algorithm = encrypter.Signature()
 	//get file length & hash (sha256)
 	hasher := sha256.New()
 	encryptedFileInfo.File.Seek(0, 0)
@@ -178,11 +180,11 @@ func (p Packager) addToStore(r *Result, info *EncryptedFileInfo) {
 	os.Remove(info.File.Name())
 }
 
-func (p Packager) addToIndex(r *Result, key []byte, name string, info *EncryptedFileInfo, contentType string) {
+func (p Packager) addToIndex(r *Result, key []byte, name string, info *EncryptedFileInfo, contentType string, metadata index.Metadata) {
 	if r.Error != nil {
 		return
 	}
-	r.Error = p.idx.Add(index.Content{ID: r.ID, EncryptionKey: key, Location: name, Length: info.Size, Sha256: info.Sha256, Type: contentType})
+	r.Error = p.idx.Add(index.Content{ID: r.ID, EncryptionKey: key, Location: name, Length: info.Size, Sha256: info.Sha256, Type: contentType, EncryptionAlgorithm: info.Algorithm, Metadata: metadata})
 }
 
 // NewPackager waits for incoming EPUB files, encrypts them and adds them to the store
@@ -5,7 +5,10 @@
 package pack
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"testing"
 	"time"
 
@@ -119,3 +122,83 @@ func TestSetMetadata(t *testing.T) {
 	manifest.Metadata.Subject.Add(rwpm.Subject{Name: "software", Scheme: "iptc", Code: "04003000"})
 
 }
+
+func TestEncryptRPFGCM(t *testing.T) {
+	encrypter, err := crypto.NewAESEncrypterForMode(crypto.ModeGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenRPF("./samples/basic.webpub")
+	if err != nil {
+		t.Fatalf("Expected to be able to open basic.webpub, got %s", err)
+	}
+	defer reader.Close()
+
+	var b bytes.Buffer
+	writer, err := reader.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("Could not build a writer, %s", err)
+	}
+	key, err := Process(encrypter, "", reader, writer)
+	if err != nil {
+		t.Fatalf("Could not encrypt the publication, %s", err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close the writer, %s", err)
+	}
+
+	// check the algorithm declared in the output manifest, then decrypt the resource
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+	mr, err := files[ManifestLocation].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest rwpm.Publication
+	err = json.NewDecoder(mr).Decode(&manifest)
+	mr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := manifest.ReadingOrder[0]
+	if link.Properties == nil || link.Properties.Encrypted == nil {
+		t.Fatalf("Expected %s to be marked as encrypted", link.Href)
+	}
+	if algorithm := link.Properties.Encrypted.Algorithm; algorithm != encrypter.Signature() {
+		t.Errorf("Expected algorithm %s, got %s", encrypter.Signature(), algorithm)
+	}
+
+	decrypter, err := crypto.NewAESDecrypter(link.Properties.Encrypted.Algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := files[link.Href].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var clear bytes.Buffer
+	if err = decrypter.Decrypt(key, rc, &clear); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := reader.Resources()[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	sourceBytes, err := ioutil.ReadAll(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sourceBytes, clear.Bytes()) {
+		t.Errorf("Expected the resource to be equal before and after")
+	}
+}
@@ -54,6 +54,19 @@ type Problem struct {
 	Detail   string `json:"detail,omitempty"`
 	Instance string `json:"instance,omitempty"`
 	//Additional members
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam is an invalid value of a request body, located by a JSON pointer
+type InvalidParam struct {
+	Pointer string `json:"pointer"`
+	Reason  string `json:"reason"`
+}
+
+// InvalidParamsError is implemented by errors listing the invalid values of a request body
+type InvalidParamsError interface {
+	error
+	InvalidParams() []InvalidParam
 }
 
 const ERROR_BASE_URL = "http://readium.org/license-status-document/error/"
@@ -94,6 +107,15 @@ func Error(w http.ResponseWriter, r *http.Request, problem Problem, status int)
 	log.Print(string(jsonError))
 }
 
+// BadRequest reports an erroneous request body, listing its invalid values if the error provides them
+func BadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	p := Problem{Detail: err.Error()}
+	if e, ok := err.(InvalidParamsError); ok {
+		p.InvalidParams = e.InvalidParams()
+	}
+	Error(w, r, p, http.StatusBadRequest)
+}
+
 func PrintStack() {
 	log.Print("####################")
 
@@ -0,0 +1,102 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package schema
+
+// partialLicenseSchema describes a partial license sent to the License Server, or a license sent to the License Status Server.
+// Mandatory properties depend on the request and are checked by the handlers; extension properties are allowed.
+const partialLicenseSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "LCP partial license",
+	"type": "object",
+	"properties": {
+		"provider": {"type": "string"},
+		"id": {"type": "string"},
+		"issued": {"type": "string", "format": "date-time"},
+		"updated": {"type": "string", "format": "date-time"},
+		"encryption": {
+			"type": "object",
+			"properties": {
+				"profile": {"type": "string"},
+				"content_key": {
+					"type": "object",
+					"properties": {
+						"algorithm": {"type": "string"},
+						"encrypted_value": {"type": "string", "contentEncoding": "base64"}
+					}
+				},
+				"user_key": {
+					"type": "object",
+					"properties": {
+						"algorithm": {"type": "string"},
+						"text_hint": {"type": "string"},
+						"key_check": {"type": "string", "contentEncoding": "base64"},
+						"value": {"type": "string", "contentEncoding": "base64"},
+						"hex_value": {"type": "string", "pattern": "^[0-9a-fA-F]{64}$"}
+					}
+				}
+			}
+		},
+		"links": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["rel", "href"],
+				"properties": {
+					"rel": {"type": "string", "minLength": 1},
+					"href": {"type": "string", "minLength": 1},
+					"type": {"type": "string"},
+					"title": {"type": "string"},
+					"profile": {"type": "string"},
+					"templated": {"type": "boolean"},
+					"length": {"type": "integer", "minimum": 0},
+					"hash": {"type": "string"}
+				}
+			}
+		},
+		"user": {
+			"type": "object",
+			"properties": {
+				"id": {"type": "string"},
+				"email": {"type": "string"},
+				"name": {"type": "string"},
+				"encrypted": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"rights": {
+			"type": "object",
+			"properties": {
+				"print": {"type": "integer", "minimum": 0, "maximum": 2147483647},
+				"copy": {"type": "integer", "minimum": 0, "maximum": 2147483647},
+				"start": {"type": "string", "format": "date-time"},
+				"end": {"type": "string", "format": "date-time"}
+			}
+		},
+		"signature": {
+			"type": "object",
+			"properties": {
+				"algorithm": {"type": "string"},
+				"certificate": {"type": "string", "contentEncoding": "base64"},
+				"value": {"type": "string", "contentEncoding": "base64"}
+			}
+		}
+	}
+}`
+
+// PartialLicense validates partial licenses
+var PartialLicense = MustCompile(partialLicenseSchema)
+
+// BulkLicenseRequest validates the items of a bulk license generation request
+var BulkLicenseRequest = MustCompile(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "LCP bulk license request",
+	"type": "object",
+	"required": ["content_id", "license"],
+	"properties": {
+		"content_id": {"type": "string", "minLength": 1},
+		"policy": {"type": "string"},
+		"profile": {"type": "string"},
+		"license": ` + partialLicenseSchema + `
+	}
+}`)
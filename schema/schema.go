@@ -0,0 +1,338 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+// Package schema validates json documents against JSON Schemas.
+// It supports the subset of JSON Schema draft 7 used by the schemas of the servers:
+// type, properties, required, additionalProperties, items, minItems, maxItems, enum,
+// minimum, maximum, minLength, maxLength, pattern, format (date-time, uri) and contentEncoding (base64).
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/readium/readium-lcp-server/problem"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	types                []string
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	enum                 []interface{}
+	minimum, maximum     *float64
+	minLength, maxLength *int
+	minItems, maxItems   *int
+	pattern              *regexp.Regexp
+	format               string
+	contentEncoding      string
+}
+
+// jsonSchema is the json representation of a schema
+type jsonSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []interface{}              `json:"enum"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Pattern              string                     `json:"pattern"`
+	Format               string                     `json:"format"`
+	ContentEncoding      string                     `json:"contentEncoding"`
+}
+
+// Error is the violation of a schema by a value, located by a JSON pointer (RFC 6901)
+type Error struct {
+	Pointer string
+	Reason  string
+}
+
+// ValidationError lists the violations of a schema by a document
+type ValidationError []Error
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, len(e))
+	for i, err := range e {
+		pointer := err.Pointer
+		if pointer == "" {
+			pointer = "/"
+		}
+		reasons[i] = pointer + ": " + err.Reason
+	}
+	return "invalid json document, " + strings.Join(reasons, "; ")
+}
+
+// InvalidParams returns the violations as invalid parameters of a problem
+func (e ValidationError) InvalidParams() []problem.InvalidParam {
+	params := make([]problem.InvalidParam, len(e))
+	for i, err := range e {
+		params[i] = problem.InvalidParam{Pointer: err.Pointer, Reason: err.Reason}
+	}
+	return params
+}
+
+// Compile parses a JSON Schema
+func Compile(src string) (*Schema, error) {
+	return compile(json.RawMessage(src))
+}
+
+// MustCompile parses a JSON Schema and panics if it is invalid; it is used for the schemas of the servers
+func MustCompile(src string) *Schema {
+	s, err := Compile(src)
+	if err != nil {
+		panic("schema: " + err.Error())
+	}
+	return s
+}
+
+func compile(raw json.RawMessage) (*Schema, error) {
+
+	var js jsonSchema
+	if err := json.Unmarshal(raw, &js); err != nil {
+		return nil, err
+	}
+	s := &Schema{
+		required:        js.Required,
+		enum:            js.Enum,
+		minimum:         js.Minimum,
+		maximum:         js.Maximum,
+		minLength:       js.MinLength,
+		maxLength:       js.MaxLength,
+		minItems:        js.MinItems,
+		maxItems:        js.MaxItems,
+		format:          js.Format,
+		contentEncoding: js.ContentEncoding,
+	}
+	if len(js.Type) > 0 {
+		var t string
+		if err := json.Unmarshal(js.Type, &t); err == nil {
+			s.types = []string{t}
+		} else if err = json.Unmarshal(js.Type, &s.types); err != nil {
+			return nil, fmt.Errorf("invalid type: %s", js.Type)
+		}
+	}
+	if len(js.Properties) > 0 {
+		s.properties = make(map[string]*Schema, len(js.Properties))
+		for name, p := range js.Properties {
+			ps, err := compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %v", name, err)
+			}
+			s.properties[name] = ps
+		}
+	}
+	if len(js.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(js.AdditionalProperties, &allowed); err == nil {
+			s.noAdditional = !allowed
+		} else {
+			ap, err := compile(js.AdditionalProperties)
+			if err != nil {
+				return nil, fmt.Errorf("additionalProperties: %v", err)
+			}
+			s.additionalProperties = ap
+		}
+	}
+	if len(js.Items) > 0 {
+		items, err := compile(js.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %v", err)
+		}
+		s.items = items
+	}
+	if js.Pattern != "" {
+		re, err := regexp.Compile(js.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.pattern = re
+	}
+	return s, nil
+}
+
+// Validate validates a json document.
+// It returns a ValidationError listing every violation of the schema, or nil if the document is valid.
+func (s *Schema) Validate(doc []byte) error {
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return ValidationError{{Pointer: "", Reason: "invalid json: " + err.Error()}}
+	}
+	if dec.More() {
+		return ValidationError{{Pointer: "", Reason: "invalid json: unexpected data after the document"}}
+	}
+	var errs ValidationError
+	s.validate(v, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *Schema) validate(v interface{}, pointer string, errs *ValidationError) {
+
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Pointer: pointer, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !s.hasType(v) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if len(s.enum) > 0 && !s.inEnum(v) {
+		values := make([]string, len(s.enum))
+		for i, e := range s.enum {
+			b, _ := json.Marshal(e)
+			values[i] = string(b)
+		}
+		fail("must be one of %s", strings.Join(values, ", "))
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			fail("must match the pattern %s", s.pattern.String())
+		}
+		if reason := checkFormat(s.format, val); reason != "" {
+			fail("%s", reason)
+		}
+		if s.contentEncoding == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(val); err != nil {
+				fail("must be base64 encoded")
+			}
+		}
+	case json.Number:
+		f, _ := val.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			fail("must be greater than or equal to %v", *s.minimum)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			fail("must be less than or equal to %v", *s.maximum)
+		}
+	case []interface{}:
+		if s.minItems != nil && len(val) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range val {
+				s.items.validate(item, pointer+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := val[name]; !ok {
+				*errs = append(*errs, Error{Pointer: pointer + "/" + escape(name), Reason: "is required"})
+			}
+		}
+		// sort the property names, for a stable list of errors
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := pointer + "/" + escape(name)
+			if ps, ok := s.properties[name]; ok {
+				ps.validate(val[name], p, errs)
+			} else if s.noAdditional {
+				*errs = append(*errs, Error{Pointer: p, Reason: "is not allowed"})
+			} else if s.additionalProperties != nil {
+				s.additionalProperties.validate(val[name], p, errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) hasType(v interface{}) bool {
+	t := typeOf(v)
+	for _, expected := range s.types {
+		if expected == t || (expected == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) inEnum(v interface{}) bool {
+	b, _ := json.Marshal(v)
+	for _, e := range s.enum {
+		eb, _ := json.Marshal(e)
+		if bytes.Equal(b, eb) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded json value
+func typeOf(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := val.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// checkFormat returns the reason why a string does not match a format, or an empty string
+func checkFormat(format, s string) string {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return "must be a RFC 3339 date-time"
+		}
+	case "uri":
+		if u, err := url.Parse(s); err != nil || u.Scheme == "" {
+			return "must be an absolute uri"
+		}
+	}
+	return ""
+}
+
+// escape escapes a property name in a JSON pointer
+func escape(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	s := MustCompile(`{
+		"type": "object",
+		"required": ["id", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+			"count": {"type": "integer", "minimum": 0, "maximum": 10},
+			"ratio": {"type": ["number", "null"]},
+			"state": {"enum": ["on", "off"]},
+			"date": {"type": "string", "format": "date-time"},
+			"href": {"type": "string", "format": "uri"},
+			"key": {"type": "string", "contentEncoding": "base64"},
+			"tags": {"type": "array", "minItems": 1, "items": {"type": "string"}},
+			"a/b": {"type": "boolean"}
+		}
+	}`)
+
+	if err := s.Validate([]byte(`{"id": "ab", "count": 3, "ratio": null, "state": "on", "date": "2022-03-01T10:00:00Z",
+		"href": "https://example.com", "key": "AQID", "tags": ["x"], "a/b": true}`)); err != nil {
+		t.Errorf("Expected a valid document, got %v", err)
+	}
+
+	err := s.Validate([]byte(`{"id": "A", "count": 3.5, "ratio": "x", "state": "dim", "date": "01/03/2022",
+		"href": "example", "key": "$$", "tags": [1], "a/b": 1, "other": 0}`))
+	expected := ValidationError{
+		{"/a~1b", "expected boolean, got integer"},
+		{"/count", "expected integer, got number"},
+		{"/date", "must be a RFC 3339 date-time"},
+		{"/href", "must be an absolute uri"},
+		{"/id", "must be at least 2 characters long"},
+		{"/id", "must match the pattern ^[a-z]+$"},
+		{"/key", "must be base64 encoded"},
+		{"/other", "is not allowed"},
+		{"/ratio", "expected number or null, got string"},
+		{"/state", `must be one of "on", "off"`},
+		{"/tags/0", "expected string, got integer"},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Unexpected errors:\n%v\nexpected:\n%v", err, expected)
+	}
+
+	err = s.Validate([]byte(`{"count": 11, "tags": []}`))
+	expected = ValidationError{
+		{"/id", "is required"},
+		{"/count", "must be less than or equal to 10"},
+		{"/tags", "must have at least 1 items"},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Unexpected errors:\n%v\nexpected:\n%v", err, expected)
+	}
+
+	if err = s.Validate([]byte(`{"id": `)); err == nil {
+		t.Error("Expected an invalid json error")
+	}
+	if err = s.Validate([]byte(`[]`)); err == nil {
+		t.Error("Expected a type error")
+	}
+}
+
+func TestPartialLicense(t *testing.T) {
+	valid := `{"provider": "https://provider.example", "user": {"id": "u1", "encrypted": ["email"], "card": 12},
+		"encryption": {"user_key": {"text_hint": "hint", "hex_value": "4981AA0A50D563040519E9032B5D74367B1D129E239A1BA82667A57333866494"}},
+		"rights": {"print": 10, "start": "2022-03-01T10:00:00Z"}}`
+	if err := PartialLicense.Validate([]byte(valid)); err != nil {
+		t.Errorf("Expected a valid partial license, got %v", err)
+	}
+
+	invalid := `{"user": {"id": 1}, "encryption": {"user_key": {"hex_value": "xyz"}}, "rights": {"print": -1, "end": "tomorrow"},
+		"links": [{"rel": "hint"}]}`
+	err, ok := PartialLicense.Validate([]byte(invalid)).(ValidationError)
+	if !ok {
+		t.Fatal("Expected a validation error")
+	}
+	pointers := make([]string, len(err))
+	for i, e := range err {
+		pointers[i] = e.Pointer
+	}
+	expected := []string{"/encryption/user_key/hex_value", "/links/0/href", "/rights/end", "/rights/print", "/user/id"}
+	if !reflect.DeepEqual(pointers, expected) {
+		t.Errorf("Unexpected pointers %v, expected %v", pointers, expected)
+	}
+	if params := err.InvalidParams(); len(params) != len(err) || params[0].Pointer != err[0].Pointer {
+		t.Errorf("Unexpected invalid params %v", params)
+	}
+}
+
+func TestLicenseNotifications(t *testing.T) {
+	if err := LsdNotification.Validate([]byte(`{"license": {"id": "l1"}, "potential_rights_end": "2022-03-01T10:00:00Z"}`)); err != nil {
+		t.Errorf("Expected a valid notification, got %v", err)
+	}
+	if err := LsdNotification.Validate([]byte(`{"license": {"id": 1}, "potential_rights_end": "soon"}`)); err == nil || len(err.(ValidationError)) != 2 {
+		t.Errorf("Expected 2 errors, got %v", err)
+	}
+	if err := LicenseUpdate.Validate([]byte(`{"id": "l1", "updated": "2022-03-01T10:00:00Z"}`)); err != nil {
+		t.Errorf("Expected a valid update, got %v", err)
+	}
+	if err := LicenseUpdate.Validate([]byte(`{"id": ""}`)); err == nil || len(err.(ValidationError)) != 2 {
+		t.Errorf("Expected 2 errors, got %v", err)
+	}
+}
+
+func TestLicenseStatus(t *testing.T) {
+	if err := LicenseStatus.Validate([]byte(`{"status": "revoked", "message": "revoked by the provider"}`)); err != nil {
+		t.Errorf("Expected a valid status document, got %v", err)
+	}
+	if err := LicenseStatus.Validate([]byte(`{"status": "deleted", "device_count": -1}`)); err == nil || len(err.(ValidationError)) != 2 {
+		t.Errorf("Expected 2 errors, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package schema
+
+// LicenseStatus validates partial license status documents sent to the License Status Server
+var LicenseStatus = MustCompile(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "LCP license status document",
+	"type": "object",
+	"required": ["status"],
+	"properties": {
+		"id": {"type": "string"},
+		"status": {"type": "string", "enum": ["ready", "active", "revoked", "returned", "cancelled", "expired"]},
+		"message": {"type": "string"},
+		"updated": {
+			"type": "object",
+			"properties": {
+				"license": {"type": "string", "format": "date-time"},
+				"status": {"type": "string", "format": "date-time"}
+			}
+		},
+		"links": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["rel", "href"],
+				"properties": {
+					"rel": {"type": "string", "minLength": 1},
+					"href": {"type": "string", "minLength": 1},
+					"type": {"type": "string"},
+					"title": {"type": "string"},
+					"profile": {"type": "string"},
+					"templated": {"type": "boolean"}
+				}
+			}
+		},
+		"device_count": {"type": "integer", "minimum": 0},
+		"potential_rights": {
+			"type": "object",
+			"properties": {
+				"end": {"type": "string", "format": "date-time"}
+			}
+		},
+		"events": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"type": {"type": "string"},
+					"name": {"type": "string"},
+					"id": {"type": "string"},
+					"timestamp": {"type": "string", "format": "date-time"}
+				}
+			}
+		}
+	}
+}`)
+
+// LsdNotification validates the items of a bulk notification of new licenses, sent by the License Server
+var LsdNotification = MustCompile(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "LCP license notification",
+	"type": "object",
+	"required": ["license"],
+	"properties": {
+		"license": ` + partialLicenseSchema + `,
+		"potential_rights_end": {"type": "string", "format": "date-time"}
+	}
+}`)
+
+// LicenseUpdate validates the items of a notification of updated licenses, sent by the License Server
+var LicenseUpdate = MustCompile(`{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "LCP license update",
+	"type": "object",
+	"required": ["id", "updated"],
+	"properties": {
+		"id": {"type": "string", "minLength": 1},
+		"updated": {"type": "string", "format": "date-time"}
+	}
+}`)
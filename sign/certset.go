@@ -0,0 +1,138 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoValidCertificate is returned when no certificate of a set is valid at a given date
+var ErrNoValidCertificate = errors.New("no signing certificate valid at this date")
+
+// KeyPairFile locates a certificate and its private key
+type KeyPairFile struct {
+	Cert       string
+	PrivateKey string
+}
+
+// CertificateSet holds the provider certificates used for signing.
+// The certificate used for a signature is selected by date, so that certificates can overlap
+// when a provider certificate nears expiry. The set can be reloaded while in use.
+type CertificateSet struct {
+	mu    sync.RWMutex
+	files []KeyPairFile
+	certs []*tls.Certificate
+}
+
+// LoadCertificateSet loads a set of certificates and their private keys
+func LoadCertificateSet(files []KeyPairFile) (*CertificateSet, error) {
+
+	if len(files) == 0 {
+		return nil, errors.New("at least one certificate is required")
+	}
+	s := &CertificateSet{files: files}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the certificate files again. On error, the current certificates are kept.
+func (s *CertificateSet) Reload() error {
+
+	certs := make([]*tls.Certificate, 0, len(s.files))
+	for _, f := range s.files {
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+		certs = append(certs, &cert)
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.mu.Unlock()
+	return nil
+}
+
+// Select returns the certificate valid at a given date. If several certificates are valid,
+// the most recent one is selected.
+func (s *CertificateSet) Select(date time.Time) (*tls.Certificate, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var selected *tls.Certificate
+	for _, cert := range s.certs {
+		if date.Before(cert.Leaf.NotBefore) || date.After(cert.Leaf.NotAfter) {
+			continue
+		}
+		if selected == nil || cert.Leaf.NotBefore.After(selected.Leaf.NotBefore) {
+			selected = cert
+		}
+	}
+	if selected == nil {
+		return nil, ErrNoValidCertificate
+	}
+	return selected, nil
+}
+
+// Signer returns a signer using the certificate valid at a given date; it implements SignerProvider
+func (s *CertificateSet) Signer(date time.Time) (Signer, error) {
+
+	cert, err := s.Select(date)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(cert)
+}
+
+// Watch reloads the certificates when one of the files is modified, checking every interval
+// until the stop channel is closed.
+func (s *CertificateSet) Watch(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	seen := s.lastModified()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime := s.lastModified()
+			if !modTime.After(seen) {
+				continue
+			}
+			seen = modTime
+			if err := s.Reload(); err != nil {
+				log.Println("Error reloading the signing certificates: " + err.Error())
+			} else {
+				log.Println("Signing certificates reloaded")
+			}
+		}
+	}
+}
+
+// lastModified returns the most recent modification time of the certificate files
+func (s *CertificateSet) lastModified() time.Time {
+
+	var last time.Time
+	for _, f := range s.files {
+		for _, path := range []string{f.Cert, f.PrivateKey} {
+			if info, err := os.Stat(path); err == nil && info.ModTime().After(last) {
+				last = info.ModTime()
+			}
+		}
+	}
+	return last
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self-signed certificate valid between two dates, and its private key
+func writeKeyPair(t *testing.T, dir, name string, notBefore, notAfter time.Time) KeyPairFile {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := KeyPairFile{Cert: filepath.Join(dir, name+".crt"), PrivateKey: filepath.Join(dir, name+".pem")}
+	if err = ioutil.WriteFile(files.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(files.PrivateKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func date(year int, month time.Month) time.Time {
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCertificateSetSelect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two certificates overlapping in 2021
+	set, err := LoadCertificateSet([]KeyPairFile{
+		writeKeyPair(t, dir, "old", date(2019, 1), date(2022, 1)),
+		writeKeyPair(t, dir, "new", date(2021, 1), date(2024, 1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for d, expected := range map[time.Time]string{
+		date(2020, 6): "old",
+		date(2021, 6): "new",
+		date(2023, 6): "new",
+	} {
+		cert, err := set.Select(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.Subject.CommonName != expected {
+			t.Errorf("Expected the %s certificate at %v, got %s", expected, d, cert.Leaf.Subject.CommonName)
+		}
+	}
+	if _, err = set.Select(date(2025, 1)); err != ErrNoValidCertificate {
+		t.Errorf("Expected ErrNoValidCertificate, got %v", err)
+	}
+
+	// the selected signer produces a signature verified with its certificate
+	signer, err := set.Signer(date(2020, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(map[string]string{"test": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := set.Select(date(2020, 6)); string(sig.Certificate) != string(cert.Certificate[0]) {
+		t.Error("Expected the signature to embed the selected certificate")
+	}
+}
+
+func TestCertificateSetReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := writeKeyPair(t, dir, "provider", date(2019, 1), date(2022, 1))
+	set, err := LoadCertificateSet([]KeyPairFile{files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = set.Select(date(2023, 1)); err != ErrNoValidCertificate {
+		t.Errorf("Expected ErrNoValidCertificate, got %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go set.Watch(10*time.Millisecond, stop)
+
+	// renew the certificate; make sure the modification time changes
+	time.Sleep(20 * time.Millisecond)
+	writeKeyPair(t, dir, "provider", date(2021, 1), date(2024, 1))
+	later := time.Now().Add(time.Second)
+	os.Chtimes(files.Cert, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err = set.Select(date(2023, 1)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the renewed certificate to be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a failed reload keeps the current certificates
+	ioutil.WriteFile(files.Cert, []byte("not a certificate"), 0600)
+	if err = set.Reload(); err == nil {
+		t.Error("Expected an error reloading an invalid certificate")
+	}
+	if _, err = set.Select(date(2023, 1)); err != nil {
+		t.Errorf("Expected the current certificate to be kept, got %v", err)
+	}
+}
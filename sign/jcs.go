@@ -0,0 +1,248 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// CanonicalizeJSON returns the canonical form of a JSON document, as defined by
+// the JSON Canonicalization Scheme (JCS, RFC 8785):
+// - no whitespace,
+// - object members sorted by the UTF-16 code units of their names,
+// - numbers serialized as ECMAScript does (IEEE 754 double precision),
+// - strings serialized in UTF-8, with the minimal set of escape sequences.
+// As JCS requires I-JSON input, objects with duplicate member names are rejected.
+func CanonicalizeJSON(in []byte) ([]byte, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+	value, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("jcs: unexpected data after the JSON value")
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeValue decodes the next JSON value token by token, as encoding/json silently keeps
+// the last member of an object with duplicate member names
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('['):
+		array := []interface{}{}
+		for dec.More() {
+			elem, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, elem)
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	case json.Delim('{'):
+		object := make(map[string]interface{})
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := tok.(string)
+			if !ok {
+				return nil, fmt.Errorf("jcs: unexpected member name %v", tok)
+			}
+			if _, exists := object[name]; exists {
+				return nil, fmt.Errorf("jcs: duplicate member name %q", name)
+			}
+			if object[name], err = decodeValue(dec); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		return object, nil
+	case json.Delim(']'), json.Delim('}'):
+		return nil, fmt.Errorf("jcs: unexpected delimiter %v", tok)
+	}
+	return tok, nil
+}
+
+// writeCanonical serializes a value decoded by encoding/json (with UseNumber)
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return fmt.Errorf("jcs: invalid number %s", v)
+		}
+		s, err := formatNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case float64:
+		s, err := formatNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case string:
+		writeString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("jcs: unexpected type %T", value)
+	}
+	return nil
+}
+
+// lessUTF16 compares two strings by their UTF-16 code units
+func lessUTF16(a, b string) bool {
+
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// writeString serializes a string, escaping only quotation marks, backslashes and control characters
+func writeString(buf *bytes.Buffer, s string) {
+
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// formatNumber serializes a number as the ECMAScript Number.prototype.toString() method does
+func formatNumber(f float64) (string, error) {
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", errors.New("jcs: NaN and Infinity are not valid JSON numbers")
+	}
+	if f == 0 {
+		// includes -0
+		return "0", nil
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// shortest decimal digits which round trip, and the decimal exponent
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp := e[:strings.IndexByte(e, 'e')], e[strings.IndexByte(e, 'e')+1:]
+	digits := strings.Replace(mantissa, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+	k := len(digits)
+	n := x + 1 // position of the decimal point relative to the digits
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k), nil
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:], nil
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits, nil
+	}
+	s := digits[:1]
+	if k > 1 {
+		s += "." + digits[1:]
+	}
+	if n-1 >= 0 {
+		s += "e+" + strconv.Itoa(n-1)
+	} else {
+		s += "e-" + strconv.Itoa(1-n)
+	}
+	return sign + s, nil
+}
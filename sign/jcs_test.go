@@ -0,0 +1,120 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"math"
+	"testing"
+)
+
+// number serialization samples, RFC 8785 appendix B
+var jcsNumbers = []struct {
+	bits     uint64
+	expected string
+}{
+	{0x0000000000000000, "0"},
+	{0x8000000000000000, "0"},
+	{0x0000000000000001, "5e-324"},
+	{0x8000000000000001, "-5e-324"},
+	{0x7fefffffffffffff, "1.7976931348623157e+308"},
+	{0xffefffffffffffff, "-1.7976931348623157e+308"},
+	{0x4340000000000000, "9007199254740992"},
+	{0xc340000000000000, "-9007199254740992"},
+	{0x4430000000000000, "295147905179352830000"},
+	{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+	{0x44b52d02c7e14af6, "1e+23"},
+	{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+	{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+	{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+	{0x444b1ae4d6e2ef50, "1e+21"},
+	{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+	{0x3eb0c6f7a0b5ed8d, "0.000001"},
+	{0x41b3de4355555553, "333333333.3333332"},
+	{0x41b3de4355555554, "333333333.33333325"},
+	{0x41b3de4355555555, "333333333.3333333"},
+	{0x41b3de4355555556, "333333333.3333334"},
+	{0x41b3de4355555557, "333333333.33333343"},
+	{0xbecbf647612f3696, "-0.0000033333333333333333"},
+	{0x43143ff3c1cb0959, "1424953923781206.2"},
+}
+
+func TestJCSNumbers(t *testing.T) {
+	for _, n := range jcsNumbers {
+		out, err := formatNumber(math.Float64frombits(n.bits))
+		if err != nil {
+			t.Errorf("%016x: %v", n.bits, err)
+			continue
+		}
+		if out != n.expected {
+			t.Errorf("%016x: expected %s, got %s", n.bits, n.expected, out)
+		}
+	}
+
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := formatNumber(f); err == nil {
+			t.Errorf("Expected an error serializing %v", f)
+		}
+	}
+}
+
+func TestJCSDocuments(t *testing.T) {
+	vectors := []struct {
+		name, input, expected string
+	}{
+		// RFC 8785 section 3.2.2
+		{"primitives",
+			`{
+				"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+				"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+				"literals": [null, true, false]
+			}`,
+			`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`},
+		// RFC 8785 section 3.2.3
+		{"sorting",
+			`{
+				"\u20ac": "Euro Sign",
+				"\r": "Carriage Return",
+				"\ufb33": "Hebrew Letter Dalet With Dagesh",
+				"1": "One",
+				"\ud83d\ude00": "Emoji: Grinning Face",
+				"\u0080": "Control",
+				"\u00f6": "Latin Small Letter O With Diaeresis"
+			}`,
+			"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\"," +
+				"\"€\":\"Euro Sign\",\"😀\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"},
+		// nested structures, empty values and characters escaped by encoding/json
+		{"structures",
+			`{"b": [{"z": 1, "a": {}}, [], ""], "a": "<\u2028&>", "c": -0.0}`,
+			"{\"a\":\"<\u2028&>\",\"b\":[{\"a\":{},\"z\":1},[],\"\"],\"c\":0}"},
+	}
+
+	for _, v := range vectors {
+		out, err := CanonicalizeJSON([]byte(v.input))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+			continue
+		}
+		if string(out) != v.expected {
+			t.Errorf("%s: expected %s, got %s", v.name, v.expected, out)
+		}
+	}
+
+	for _, invalid := range []string{`{"a":1}{}`, `{"a":1e400}`, `{"a":`, `{"a":1,"a":2}`, `[{"b":{"c":1,"c":1}}]`} {
+		if _, err := CanonicalizeJSON([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error canonicalizing %s", invalid)
+		}
+	}
+}
+
+func TestCanonUnicode(t *testing.T) {
+	// encoding/json escapes HTML characters, JCS does not
+	out, err := Canon(map[string]string{"user": "Zoë <zoe@example.com>", "name": "日本語"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"name":"日本語","user":"Zoë <zoe@example.com>"}`; string(out) != expected {
+		t.Errorf("Expected %s, got %s", expected, out)
+	}
+}
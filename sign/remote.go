@@ -0,0 +1,178 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// SignerProvider returns the signer to use for a document issued or updated at a given date
+type SignerProvider interface {
+	Signer(date time.Time) (Signer, error)
+}
+
+// RemoteSignerOptions configures the access to a remote signing service
+type RemoteSignerOptions struct {
+	URL      string
+	KeyID    string
+	Username string
+	Password string
+	Timeout  time.Duration // per request, 10 seconds by default
+	Retries  int           // additional attempts after a network or server error
+}
+
+// RemoteSigner signs documents via a remote signing service holding the provider private key.
+// Only the provider certificate is held locally, as it is embedded in the signature.
+//
+// The service receives a POST request with the JSON body
+//
+//	{"key_id": "...", "algorithm": "<signature algorithm URI>", "digest": "<base64 SHA-256 digest>"}
+//
+// and replies with the JSON body
+//
+//	{"value": "<base64 signature value>"}
+//
+// The value is a PKCS#1 v1.5 signature for an RSA key, the concatenation of r and s for an ECDSA key.
+type RemoteSigner struct {
+	cert      *x509.Certificate
+	algorithm string
+	options   RemoteSignerOptions
+	client    *http.Client
+}
+
+type remoteSignRequest struct {
+	KeyID     string `json:"key_id,omitempty"`
+	Algorithm string `json:"algorithm"`
+	Digest    []byte `json:"digest"`
+}
+
+type remoteSignResponse struct {
+	Value []byte `json:"value"`
+}
+
+// NewRemoteSigner returns a signer using a remote signing service and the provider certificate found in a PEM file
+func NewRemoteSigner(certFile string, options RemoteSignerOptions) (*RemoteSigner, error) {
+
+	if options.URL == "" {
+		return nil, errors.New("the remote signing service URL is required")
+	}
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found in " + certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RemoteSigner{cert: cert, options: options}
+	switch cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		s.algorithm = ecdsaSha256Algorithm
+	case *rsa.PublicKey:
+		s.algorithm = rsaSha256Algorithm
+	default:
+		return nil, errors.New("Unsupported certificate type")
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	s.client = &http.Client{Timeout: timeout}
+	return s, nil
+}
+
+// Signer returns the remote signer if its certificate is valid at a given date
+func (s *RemoteSigner) Signer(date time.Time) (Signer, error) {
+
+	if date.Before(s.cert.NotBefore) || date.After(s.cert.NotAfter) {
+		return nil, ErrNoValidCertificate
+	}
+	return s, nil
+}
+
+// Sign canonicalizes a document and has its digest signed by the remote service.
+// The signature value returned by the service is checked against the certificate.
+func (s *RemoteSigner) Sign(in interface{}) (sig Signature, err error) {
+
+	plain, err := Canon(in)
+	if err != nil {
+		return
+	}
+	hashed := sha256.Sum256(plain)
+	body, err := json.Marshal(remoteSignRequest{KeyID: s.options.KeyID, Algorithm: s.algorithm, Digest: hashed[:]})
+	if err != nil {
+		return
+	}
+
+	var value []byte
+	for attempt := 0; attempt <= s.options.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		var retry bool
+		value, retry, err = s.post(body)
+		if err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	sig = Signature{Certificate: s.cert.Raw, Value: value, Algorithm: s.algorithm}
+	if err = checkSignatureValue(s.cert, sig, plain); err != nil {
+		return Signature{}, fmt.Errorf("remote signing: %s", err.Error())
+	}
+	return
+}
+
+// post sends a signing request, and tells if the request can be retried on error
+func (s *RemoteSigner) post(body []byte) ([]byte, bool, error) {
+
+	req, err := http.NewRequest("POST", s.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.options.Username != "" {
+		req.SetBasicAuth(s.options.Username, s.options.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// network error or timeout
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("remote signing: unexpected status %d", resp.StatusCode)
+		return nil, resp.StatusCode >= 500, err
+	}
+
+	var res remoteSignResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, false, err
+	}
+	if len(res.Value) == 0 {
+		return nil, false, errors.New("remote signing: empty signature value")
+	}
+	return res.Value, false, nil
+}
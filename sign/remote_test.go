@@ -0,0 +1,158 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubSigningService signs digests with a local private key, as a remote signing service would
+func stubSigningService(t *testing.T, cert tls.Certificate, failures int32, delay time.Duration) (*httptest.Server, *int32) {
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&calls, 1); n <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(delay)
+		if user, pass, _ := r.BasicAuth(); user != "signer" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req remoteSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID != "provider-key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var res remoteSignResponse
+		var err error
+		switch key := cert.PrivateKey.(type) {
+		case *rsa.PrivateKey:
+			res.Value, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, req.Digest)
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, key, req.Digest)
+			size := int(math.Ceil(float64(key.Curve.Params().BitSize) / 8))
+			res.Value = make([]byte, 2*size)
+			copyWithLeftPad(res.Value[:size], r.Bytes())
+			copyWithLeftPad(res.Value[size:], s.Bytes())
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	return server, &calls
+}
+
+func TestRemoteSigner(t *testing.T) {
+	for _, sample := range []string{"rsa", "ecdsa"} {
+		cert, err := tls.LoadX509KeyPair("cert/sample_"+sample+".crt", "cert/sample_"+sample+".pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, calls := stubSigningService(t, cert, 1, 0)
+		defer server.Close()
+
+		signer, err := NewRemoteSigner("cert/sample_"+sample+".crt", RemoteSignerOptions{
+			URL: server.URL, KeyID: "provider-key", Username: "signer", Password: "secret", Retries: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the first call fails, the request is retried
+		doc := signedDoc{ID: "1", Issued: time.Date(2016, 3, 10, 0, 0, 0, 0, time.UTC), User: "Zoë"}
+		sig, err := signer.Sign(doc)
+		if err != nil {
+			t.Fatalf("%s: %v", sample, err)
+		}
+		if *calls != 2 {
+			t.Errorf("%s: expected 2 calls, got %d", sample, *calls)
+		}
+		if err = checkSignatureValue(signer.cert, sig, mustCanon(t, doc)); err != nil {
+			t.Errorf("%s: expected a valid signature, got %v", sample, err)
+		}
+	}
+}
+
+func TestRemoteSignerErrors(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("cert/sample_rsa.crt", "cert/sample_rsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := RemoteSignerOptions{KeyID: "provider-key", Username: "signer", Password: "secret"}
+
+	// server errors beyond the retries
+	server, calls := stubSigningService(t, cert, 3, 0)
+	defer server.Close()
+	options.URL, options.Retries = server.URL, 1
+	signer, err := NewRemoteSigner("cert/sample_rsa.crt", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(map[string]string{"test": "test"}); err == nil || *calls != 2 {
+		t.Errorf("Expected an error after 2 calls, got %v after %d calls", err, *calls)
+	}
+
+	// client errors are not retried
+	server, calls = stubSigningService(t, cert, 0, 0)
+	defer server.Close()
+	options.URL, options.Password = server.URL, "wrong"
+	signer, _ = NewRemoteSigner("cert/sample_rsa.crt", options)
+	if _, err = signer.Sign(map[string]string{"test": "test"}); err == nil || *calls != 1 {
+		t.Errorf("Expected an error after 1 call, got %v after %d calls", err, *calls)
+	}
+
+	// timeout
+	server, _ = stubSigningService(t, cert, 0, 200*time.Millisecond)
+	defer server.Close()
+	options.URL, options.Password, options.Timeout, options.Retries = server.URL, "secret", 50*time.Millisecond, 0
+	signer, _ = NewRemoteSigner("cert/sample_rsa.crt", options)
+	if _, err = signer.Sign(map[string]string{"test": "test"}); err == nil {
+		t.Error("Expected a timeout")
+	}
+
+	// a signature made with another key is rejected
+	other, err := tls.LoadX509KeyPair("cert/sample_ecdsa.crt", "cert/sample_ecdsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ = stubSigningService(t, other, 0, 0)
+	defer server.Close()
+	options.URL, options.Timeout = server.URL, 0
+	signer, _ = NewRemoteSigner("cert/sample_rsa.crt", options)
+	if _, err = signer.Sign(map[string]string{"test": "test"}); err == nil {
+		t.Error("Expected an invalid signature error")
+	}
+
+	// the certificate validity is checked when the signer is selected
+	if _, err = signer.Signer(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); err != ErrNoValidCertificate {
+		t.Errorf("Expected ErrNoValidCertificate, got %v", err)
+	}
+}
+
+func mustCanon(t *testing.T, in interface{}) []byte {
+	b, err := Canon(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -81,7 +81,7 @@ func (signer *ecdsaSigner) Sign(in interface{}) (sig Signature, err error) {
 	copyWithLeftPad(sig.Value[0:curveSizeInBytes], r.Bytes())
 	copyWithLeftPad(sig.Value[curveSizeInBytes:], s.Bytes())
 
-	sig.Algorithm = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	sig.Algorithm = ecdsaSha256Algorithm
 	sig.Certificate = signer.cert.Certificate[0]
 	return
 }
@@ -104,7 +104,7 @@ func (signer *rsaSigner) Sign(in interface{}) (sig Signature, err error) {
 		return
 	}
 
-	sig.Algorithm = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	sig.Algorithm = rsaSha256Algorithm
 	sig.Certificate = signer.cert.Certificate[0]
 
 	return
@@ -0,0 +1,155 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+const (
+	rsaSha256Algorithm   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	ecdsaSha256Algorithm = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+)
+
+// ErrNoSignature is returned when the signed document has no signature
+var ErrNoSignature = errors.New("the document is not signed")
+
+// ErrInvalidSignature is returned when the signature value does not match the document
+var ErrInvalidSignature = errors.New("invalid signature value")
+
+// ErrUnsupportedAlgorithm is returned when the signature algorithm is neither RSA nor ECDSA with SHA256
+var ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+
+// Verify checks the signature of a signed document, typically a license, given as a structure
+// or as a json.RawMessage. The document without its signature is canonicalized, and the signature value
+// is checked against the embedded certificate. This certificate must chain to one of the roots
+// (the provider CA), and must be valid at the date the document was updated or issued,
+// or at the current date if the document has no such date.
+func Verify(in interface{}, roots *x509.CertPool) error {
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&doc); err != nil {
+		return err
+	}
+
+	// extract the signature, then canonicalize the document as it was before signing
+	rawSig, ok := doc["signature"]
+	if !ok || rawSig == nil {
+		return ErrNoSignature
+	}
+	delete(doc, "signature")
+	b, err = json.Marshal(rawSig)
+	if err != nil {
+		return err
+	}
+	var sig Signature
+	if err = json.Unmarshal(b, &sig); err != nil {
+		return err
+	}
+	plain, err := Canon(doc)
+	if err != nil {
+		return err
+	}
+
+	cert, err := x509.ParseCertificate(sig.Certificate)
+	if err != nil {
+		return err
+	}
+	if err = checkSignatureValue(cert, sig, plain); err != nil {
+		return err
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: signingTime(doc),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// checkSignatureValue checks the signature value of a canonicalized document
+func checkSignatureValue(cert *x509.Certificate, sig Signature, plain []byte) error {
+
+	hashed := sha256.Sum256(plain)
+	switch sig.Algorithm {
+	case rsaSha256Algorithm:
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig.Value) != nil {
+			return ErrInvalidSignature
+		}
+	case ecdsaSha256Algorithm:
+		key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		// the signature value is the concatenation of r and s (see ecdsaSigner)
+		half := len(sig.Value) / 2
+		r := new(big.Int).SetBytes(sig.Value[:half])
+		s := new(big.Int).SetBytes(sig.Value[half:])
+		if !ecdsa.Verify(key, hashed[:], r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// signingTime returns the date the document was updated or issued, the current date by default
+func signingTime(doc map[string]interface{}) time.Time {
+
+	for _, field := range []string{"updated", "issued"} {
+		if s, ok := doc[field].(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Now()
+}
+
+// LoadRoots returns a pool of the PEM encoded certificates found in a file, typically the provider CA
+func LoadRoots(pemFile string) (*x509.CertPool, error) {
+
+	b, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		roots.AddCert(cert)
+	}
+	if len(roots.Subjects()) == 0 {
+		return nil, errors.New("no certificate found in " + pemFile)
+	}
+	return roots, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type signedDoc struct {
+	ID        string     `json:"id"`
+	Issued    time.Time  `json:"issued"`
+	Updated   *time.Time `json:"updated,omitempty"`
+	User      string     `json:"user"`
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// newTestCA returns a CA and a provider certificate issued by this CA, valid in 2020
+func newTestCA(t *testing.T) (*x509.CertPool, *tls.Certificate) {
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Provider"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return roots, &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func signDoc(t *testing.T, cert *tls.Certificate, doc *signedDoc) {
+
+	signer, err := NewSigner(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Signature = &sig
+}
+
+func TestVerify(t *testing.T) {
+	roots, cert := newTestCA(t)
+
+	doc := signedDoc{ID: "1", Issued: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), User: "Zoë Ω"}
+	signDoc(t, cert, &doc)
+	if err := Verify(doc, roots); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	// the serialized document is verified the same way
+	b, _ := json.Marshal(doc)
+	if err := Verify(json.RawMessage(b), roots); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	// a modified document
+	tampered := doc
+	tampered.User = "Mallory"
+	if err := Verify(tampered, roots); err != ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+
+	// an unsigned document
+	if err := Verify(signedDoc{ID: "1"}, roots); err != ErrNoSignature {
+		t.Errorf("Expected ErrNoSignature, got %v", err)
+	}
+
+	// a certificate issued by another CA
+	otherRoots, _ := newTestCA(t)
+	if err := Verify(doc, otherRoots); err == nil {
+		t.Error("Expected an error with an unknown CA")
+	}
+}
+
+func TestVerifyValidity(t *testing.T) {
+	roots, cert := newTestCA(t)
+
+	// issued after the expiration of the certificate
+	doc := signedDoc{ID: "1", Issued: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	signDoc(t, cert, &doc)
+	if _, ok := Verify(doc, roots).(x509.CertificateInvalidError); !ok {
+		t.Error("Expected an invalid certificate error")
+	}
+
+	// the update date takes precedence over the issue date
+	updated := time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)
+	doc = signedDoc{ID: "1", Issued: time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), Updated: &updated}
+	signDoc(t, cert, &doc)
+	if err := Verify(doc, roots); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+}
+
+func TestVerifyRSA(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("cert/sample_rsa.crt", "cert/sample_rsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := LoadRoots("cert/sample_rsa.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the self-signed sample certificate is valid in 2014
+	doc := signedDoc{ID: "1", Issued: time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)}
+	signDoc(t, &cert, &doc)
+	if err = Verify(doc, roots); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+}
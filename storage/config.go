@@ -0,0 +1,63 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/readium/readium-lcp-server/config"
+)
+
+// New creates the storage defined in a configuration: a file system, S3, WebDAV or mirrored storage,
+// or a void storage if no storage is configured.
+func New(conf config.Storage) (Store, error) {
+
+	switch conf.Mode {
+	case "s3":
+		return S3(S3Config{
+			ID:             conf.AccessId,
+			Secret:         conf.Secret,
+			Token:          conf.Token,
+			Endpoint:       conf.Endpoint,
+			Bucket:         conf.Bucket,
+			Region:         conf.Region,
+			DisableSSL:     conf.DisableSSL,
+			ForcePathStyle: conf.PathStyle,
+		})
+	case "webdav":
+		return WebDAV(WebDAVConfig{
+			URL:       conf.WebDAV.URL,
+			Username:  conf.WebDAV.Username,
+			Password:  conf.WebDAV.Password,
+			PublicURL: conf.WebDAV.PublicURL,
+		})
+	case "mirror":
+		if conf.Mirror == nil {
+			return nil, errors.New("the mirror storage requires a mirror section")
+		}
+		primary, err := New(conf.Mirror.Primary)
+		if err != nil {
+			return nil, err
+		}
+		secondary, err := New(conf.Mirror.Secondary)
+		if err != nil {
+			return nil, err
+		}
+		mirror, err := NewMirror(primary, secondary, conf.Mirror.WritePolicy, time.Duration(conf.Mirror.RetryInterval)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		// the mirror lives as long as the process
+		go mirror.Replay(nil)
+		return mirror, nil
+	}
+	if conf.FileSystem.Directory != "" {
+		os.MkdirAll(conf.FileSystem.Directory, os.ModePerm) //ignore the error, the folder can already exist
+		return NewFileSystem(conf.FileSystem.Directory, conf.FileSystem.URL), nil
+	}
+	return NoStorage(), nil
+}
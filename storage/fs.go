@@ -26,10 +26,12 @@
 package storage
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type fsStorage struct {
@@ -41,6 +43,12 @@ type fsItem struct {
 	name       string
 	storageDir string
 	baseURL    string
+	size       int64
+	modTime    time.Time
+}
+
+func newFsItem(fi os.FileInfo, storageDir, baseURL string) *fsItem {
+	return &fsItem{name: fi.Name(), storageDir: storageDir, baseURL: baseURL, size: fi.Size(), modTime: fi.ModTime()}
 }
 
 func (i fsItem) Key() string {
@@ -55,36 +63,74 @@ func (i fsItem) Contents() (io.ReadCloser, error) {
 	return os.Open(filepath.Join(i.storageDir, i.name))
 }
 
+func (i fsItem) ContentsRange(offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(i.storageDir, i.name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return limitedReadCloser{io.LimitReader(file, length), file}, nil
+}
+
+func (i fsItem) Size() int64 {
+	return i.size
+}
+
+// ETag is computed from the modification time and size of the file
+func (i fsItem) ETag() string {
+	return fmt.Sprintf(`"%x-%x"`, i.modTime.UnixNano(), i.size)
+}
+
+func (i fsItem) LastModified() time.Time {
+	return i.modTime
+}
+
 func (s fsStorage) Add(key string, r io.ReadSeeker) (Item, error) {
 	file, err := os.Create(filepath.Join(s.fspath, key))
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	io.Copy(file, r)
-
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &fsItem{name: key, storageDir: s.fspath, baseURL: s.url}, nil
+	return s.Get(key)
 }
 
 // Get returns an Item in the storage, by its key
 // the key is the file name
 //
 func (s fsStorage) Get(key string) (Item, error) {
-	_, err := os.Stat(filepath.Join(s.fspath, key))
+	fi, err := os.Stat(filepath.Join(s.fspath, key))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	return &fsItem{name: key, storageDir: s.fspath, baseURL: s.url}, nil
+	return newFsItem(fi, s.fspath, s.url), nil
 }
 
 func (s fsStorage) Remove(key string) error {
-	return os.Remove(filepath.Join(s.fspath, key))
+	err := os.Remove(filepath.Join(s.fspath, key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ComparableETags is false, as the ETags of the files depend on their modification time
+func (s fsStorage) ComparableETags() bool {
+	return false
 }
 
 func (s fsStorage) List() ([]Item, error) {
@@ -96,7 +142,7 @@ func (s fsStorage) List() ([]Item, error) {
 	}
 
 	for _, fi := range files {
-		items = append(items, &fsItem{name: fi.Name(), storageDir: s.fspath, baseURL: s.url})
+		items = append(items, newFsItem(fi, s.fspath, s.url))
 	}
 
 	return items, nil
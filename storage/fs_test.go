@@ -26,9 +26,11 @@
 package storage
 
 import (
+	"archive/zip"
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -95,3 +97,150 @@ func TestFileSystemStorage(t *testing.T) {
 	}
 
 }
+
+func TestFileSystemRanges(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "lcpserve_test_store", fmt.Sprintf("%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int()))
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSystem(dir, "http://localhost/assets")
+	if _, err = store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = store.Add("test", bytes.NewReader([]byte("0123456789"))); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.Get("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Size() != 10 {
+		t.Errorf("Expected size 10, got %d", item.Size())
+	}
+	if item.ETag() == "" || item.LastModified().IsZero() {
+		t.Error("Expected an ETag and a modification time")
+	}
+
+	read := func(rc io.ReadCloser, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		var buf bytes.Buffer
+		if _, err = io.Copy(&buf, rc); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	if s := read(item.ContentsRange(2, 3)); s != "234" {
+		t.Errorf("Expected 234, got %s", s)
+	}
+	if s := read(item.ContentsRange(7, -1)); s != "789" {
+		t.Errorf("Expected 789, got %s", s)
+	}
+
+	// random reads
+	reader := NewItemReader(item)
+	defer reader.Close()
+	var buf [4]byte
+	if n, err := reader.ReadAt(buf[:], 6); n != 4 || err != nil || string(buf[:]) != "6789" {
+		t.Errorf("Expected 6789, got %s (%d, %v)", buf[:n], n, err)
+	}
+	if n, err := reader.ReadAt(buf[:], 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Errorf("Expected 89 and EOF, got %s (%d, %v)", buf[:n], n, err)
+	}
+	if _, err = reader.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if s := read(reader, nil); s != "789" {
+		t.Errorf("Expected 789, got %s", s)
+	}
+}
+
+// rangeCounter counts the ranged reads of an item
+type rangeCounter struct {
+	Item
+	ranges    int
+	unbounded int
+}
+
+func (i *rangeCounter) ContentsRange(offset, length int64) (io.ReadCloser, error) {
+	i.ranges++
+	if length < 0 {
+		i.unbounded++
+	}
+	return i.Item.ContentsRange(offset, length)
+}
+
+func TestItemReaderZip(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "lcpserve_test_store", fmt.Sprintf("%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int()))
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a zip with many small entries and a large one
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	entries := make(map[string][]byte)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		size := 1000
+		if i == 50 {
+			size = 4 << 20
+		}
+		data := make([]byte, size)
+		rnd.Read(data)
+		name := fmt.Sprintf("entry%d", i)
+		entries[name] = data
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileSystem(dir, "")
+	if _, err = store.Add("test.zip", bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get("test.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &rangeCounter{Item: stored}
+	reader := NewItemReader(item)
+	defer reader.Close()
+	zr, err := zip.NewReader(reader, item.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, entries[f.Name]) {
+			t.Errorf("Unexpected contents of %s", f.Name)
+		}
+	}
+	// the entries are read in file order: the ranges cover several entries, and grow over the large entry
+	if item.unbounded != 0 {
+		t.Errorf("Expected bounded ranges only, got %d unbounded ranges", item.unbounded)
+	}
+	if item.ranges > 20 {
+		t.Errorf("Expected at most 20 ranged reads for %d entries, got %d", len(zr.File), item.ranges)
+	}
+}
@@ -28,6 +28,7 @@ package storage
 import (
 	"errors"
 	"io"
+	"time"
 )
 
 // ErrNotFound is not found
@@ -38,6 +39,14 @@ type Item interface {
 	Key() string
 	PublicURL() string
 	Contents() (io.ReadCloser, error)
+	// ContentsRange returns length bytes of the contents, starting at offset;
+	// the contents are read up to the end if length is negative
+	ContentsRange(offset, length int64) (io.ReadCloser, error)
+	// Size returns the size of the contents, -1 if unknown
+	Size() int64
+	// ETag returns an opaque identifier of the current contents, as a quoted string, or an empty string if unknown
+	ETag() string
+	LastModified() time.Time
 }
 
 // Store interface
@@ -46,4 +55,7 @@ type Store interface {
 	Get(key string) (Item, error)
 	Remove(key string) error
 	List() ([]Item, error)
+	// ComparableETags reports whether the ETags of the items depend on their contents only,
+	// so that they can be compared with the ETags of the items of another backend
+	ComparableETags() bool
 }
@@ -0,0 +1,365 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Write policies of a mirrored storage
+const (
+	// writes must succeed on both backends
+	MirrorSync = "sync"
+	// writes to the secondary backend are asynchronous, and retried until they succeed
+	MirrorAsync = "async"
+)
+
+// DefaultRetryInterval is the default interval between two retries of the asynchronous writes
+const DefaultRetryInterval = time.Minute
+
+// Mirror is a storage which writes items to a primary and a secondary backend,
+// and reads them from the primary backend, falling back to the secondary one.
+// With the async policy, the pending writes to the secondary backend are kept in memory only:
+// they are lost if the server stops, and a repair of the secondary backend copies the missing items.
+type Mirror struct {
+	Primary   Store
+	Secondary Store
+	policy    string
+	interval  time.Duration
+
+	mu      sync.Mutex
+	pending map[string]bool // keys of the items to copy (true) or remove (false) from the secondary backend
+	wake    chan struct{}
+}
+
+// NewMirror creates a mirrored storage; with the async policy,
+// Replay must run in a goroutine for writing the pending items to the secondary backend.
+func NewMirror(primary, secondary Store, policy string, retryInterval time.Duration) (*Mirror, error) {
+	switch policy {
+	case "":
+		policy = MirrorSync
+	case MirrorSync, MirrorAsync:
+	default:
+		return nil, fmt.Errorf("unknown mirror write policy %s", policy)
+	}
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	m := &Mirror{
+		Primary:   primary,
+		Secondary: secondary,
+		policy:    policy,
+		interval:  retryInterval,
+		pending:   make(map[string]bool),
+		wake:      make(chan struct{}, 1),
+	}
+	return m, nil
+}
+
+// Add stores an item in both backends; the item stored in the primary backend is returned.
+// With the sync policy, an error is returned if the write to the secondary backend fails, but the item
+// remains stored in the primary backend, replacing any previous item: a repair copies it to the secondary backend.
+func (m *Mirror) Add(key string, r io.ReadSeeker) (Item, error) {
+	item, err := m.Primary.Add(key, r)
+	if err != nil {
+		return nil, err
+	}
+	if m.policy == MirrorAsync {
+		m.enqueue(key, true)
+		return item, nil
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err = m.Secondary.Add(key, r); err != nil {
+		return nil, fmt.Errorf("secondary storage: %w", err)
+	}
+	return item, nil
+}
+
+// Get returns an item from the primary backend, or from the secondary one if the primary backend fails
+func (m *Mirror) Get(key string) (Item, error) {
+	item, err := m.Primary.Get(key)
+	if err == nil {
+		return item, nil
+	}
+	if item, err2 := m.Secondary.Get(key); err2 == nil {
+		return item, nil
+	}
+	return nil, err
+}
+
+// Remove removes an item from both backends; ErrNotFound is returned if the item is in neither of them
+func (m *Mirror) Remove(key string) error {
+	err := m.Primary.Remove(key)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if m.policy == MirrorAsync {
+		m.enqueue(key, false)
+		return err
+	}
+	err2 := m.Secondary.Remove(key)
+	if err2 == ErrNotFound {
+		return err
+	}
+	if err2 != nil {
+		return fmt.Errorf("secondary storage: %w", err2)
+	}
+	return nil
+}
+
+// List returns the items of both backends, preferably from the primary backend
+func (m *Mirror) List() ([]Item, error) {
+	items, err := m.Primary.List()
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(items))
+	for _, item := range items {
+		keys[item.Key()] = true
+	}
+	secondary, err := m.Secondary.List()
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	for _, item := range secondary {
+		if !keys[item.Key()] {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// ComparableETags is true if the ETags of both backends are comparable
+func (m *Mirror) ComparableETags() bool {
+	return m.Primary.ComparableETags() && m.Secondary.ComparableETags()
+}
+
+// Pending returns the number of asynchronous writes not yet done on the secondary backend
+func (m *Mirror) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+// enqueue records a write to replay on the secondary backend; a later write of the same key replaces it
+func (m *Mirror) enqueue(key string, add bool) {
+	m.mu.Lock()
+	m.pending[key] = add
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Replay writes the pending items to the secondary backend, then retries the failed writes every retry interval,
+// until the stop channel is closed. It returns immediately with the sync policy.
+func (m *Mirror) Replay(stop <-chan struct{}) {
+	if m.policy != MirrorAsync {
+		return
+	}
+	log.Println("Mirror: the pending writes to the secondary storage are kept in memory and lost if the server stops;",
+		"repair the secondary storage after a restart")
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if n := m.Pending(); n > 0 {
+				log.Println("Mirror:", n, "pending writes to the secondary storage are dropped, repair the secondary storage")
+			}
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		keys := make(map[string]bool, len(m.pending))
+		for key, add := range m.pending {
+			keys[key] = add
+		}
+		m.mu.Unlock()
+
+		for key, add := range keys {
+			var err error
+			if add {
+				err = m.copyToSecondary(key)
+			} else if err = m.Secondary.Remove(key); err == ErrNotFound {
+				err = nil
+			}
+			if err != nil {
+				log.Println("Mirror: write of", key, "to the secondary storage failed, will retry:", err.Error())
+				continue
+			}
+			m.mu.Lock()
+			// the key may have been enqueued again in the meantime
+			if m.pending[key] == add {
+				delete(m.pending, key)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// copyToSecondary copies the current item of the primary backend to the secondary one
+func (m *Mirror) copyToSecondary(key string) error {
+	item, err := m.Primary.Get(key)
+	if err == ErrNotFound {
+		// the item has been removed since
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return CopyItem(item, m.Secondary)
+}
+
+// CopyItem copies an item to a store, under the same key.
+// The contents are spooled to a temporary file, as the store requires a seekable reader.
+func CopyItem(item Item, dst Store) error {
+	contents, err := item.Contents()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	f, err := ioutil.TempFile("", "readium-lcp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if _, err = io.Copy(f, contents); err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = dst.Add(item.Key(), f)
+	return err
+}
+
+// Directions of a repair of a mirrored storage
+const (
+	// the items of the primary backend are copied to the secondary one
+	RepairToSecondary = "to_secondary"
+	// the items of the secondary backend are copied to the primary one, e.g. after the loss of the primary backend
+	RepairToPrimary = "to_primary"
+)
+
+// RepairReport lists the items copied by a repair of a mirrored storage
+type RepairReport struct {
+	Direction string `json:"direction"`
+	// items missing from the destination backend
+	Copied []string `json:"copied"`
+	// items of the destination backend which differ from the source backend
+	Updated []string `json:"updated"`
+	// items only in the destination backend, which are not removed as they may be deleted items
+	Extra []string `json:"extra"`
+	// items with an asynchronous write in progress, left to this write
+	Pending []string          `json:"pending"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Repair copies the items missing or stale in one backend from the other backend.
+// A repair is one-way, from the primary to the secondary backend unless the direction is RepairToPrimary:
+// an item missing from the source backend may have been deleted, so it is reported but neither copied back nor removed.
+// An item is stale if its size differs, if the source item is more recent than the secondary copy,
+// or if the ETags differ when both backends compute them from the contents of the items.
+// If dryRun is set, the items to repair are reported but not copied.
+func (m *Mirror) Repair(direction string, dryRun bool) (RepairReport, error) {
+	src, dst := m.Primary, m.Secondary
+	switch direction {
+	case "":
+		direction = RepairToSecondary
+	case RepairToSecondary:
+	case RepairToPrimary:
+		src, dst = m.Secondary, m.Primary
+	default:
+		return RepairReport{}, fmt.Errorf("unknown repair direction %s", direction)
+	}
+	report := RepairReport{Direction: direction, Copied: []string{}, Updated: []string{}, Extra: []string{}, Pending: []string{}}
+	items, err := src.List()
+	if err != nil && err != ErrNotFound {
+		return report, err
+	}
+	present, err := dst.List()
+	if err != nil && err != ErrNotFound {
+		return report, err
+	}
+	compareETags := src.ComparableETags() && dst.ComparableETags()
+	copies := make(map[string]Item, len(present))
+	for _, item := range present {
+		copies[item.Key()] = item
+	}
+
+	m.mu.Lock()
+	pending := make(map[string]bool, len(m.pending))
+	for key := range m.pending {
+		pending[key] = true
+	}
+	m.mu.Unlock()
+
+	for _, item := range items {
+		key := item.Key()
+		dstItem, found := copies[key]
+		delete(copies, key)
+		if pending[key] {
+			report.Pending = append(report.Pending, key)
+			continue
+		}
+		var list *[]string
+		switch {
+		case !found:
+			list = &report.Copied
+		case isStale(dstItem, item, direction == RepairToSecondary, compareETags):
+			list = &report.Updated
+		default:
+			continue
+		}
+		if !dryRun {
+			if err := CopyItem(item, dst); err != nil {
+				if report.Errors == nil {
+					report.Errors = make(map[string]string)
+				}
+				report.Errors[key] = err.Error()
+				continue
+			}
+		}
+		*list = append(*list, key)
+	}
+	for key := range copies {
+		if pending[key] {
+			report.Pending = append(report.Pending, key)
+		} else {
+			report.Extra = append(report.Extra, key)
+		}
+	}
+	for _, list := range [][]string{report.Copied, report.Updated, report.Extra, report.Pending} {
+		sort.Strings(list)
+	}
+	return report, nil
+}
+
+// isStale checks if the replica of an item differs from the item.
+// If the replica is written after the item, as the secondary backend is, a replica older than the item is stale.
+// The ETags are compared only if both backends compute them from the contents of the items.
+func isStale(replica, item Item, writtenAfter bool, compareETags bool) bool {
+	if replica.Size() != item.Size() {
+		return true
+	}
+	if writtenAfter && !item.LastModified().IsZero() && replica.LastModified().Before(item.LastModified()) {
+		return true
+	}
+	return compareETags && replica.ETag() != "" && replica.ETag() != item.ETag()
+}
@@ -0,0 +1,268 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore fails its writes while it is down
+type flakyStore struct {
+	Store
+	mu   sync.Mutex
+	down bool
+}
+
+func (s *flakyStore) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *flakyStore) Add(key string, r io.ReadSeeker) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return nil, errors.New("storage down")
+	}
+	return s.Store.Add(key, r)
+}
+
+func newTempStore(t *testing.T) (Store, func()) {
+	dir, err := ioutil.TempDir("", "lcpserve_test_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFileSystem(dir, "http://localhost/assets"), func() { os.RemoveAll(dir) }
+}
+
+func readItem(t *testing.T, item Item) string {
+	contents, err := item.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer contents.Close()
+	b, err := ioutil.ReadAll(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMirrorSync(t *testing.T) {
+	primary, clean1 := newTempStore(t)
+	defer clean1()
+	fs2, clean2 := newTempStore(t)
+	defer clean2()
+	secondary := &flakyStore{Store: fs2}
+
+	mirror, err := NewMirror(primary, secondary, MirrorSync, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mirror.Add("a", bytes.NewReader([]byte("aaaa"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = secondary.Get("a"); err != nil {
+		t.Errorf("Expected the item in the secondary storage, got %v", err)
+	}
+
+	// both writes must succeed; the item stays in the primary storage
+	secondary.setDown(true)
+	if _, err = mirror.Add("b", bytes.NewReader([]byte("bbbb"))); err == nil {
+		t.Error("Expected an error from the secondary storage")
+	}
+	secondary.setDown(false)
+	if _, err = primary.Get("b"); err != nil {
+		t.Errorf("Expected b in the primary storage, got %v", err)
+	}
+
+	// reads fall back to the secondary storage
+	if err = primary.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	item, err := mirror.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readItem(t, item); s != "aaaa" {
+		t.Errorf("Expected aaaa, got %s", s)
+	}
+	items, err := mirror.List()
+	if err != nil || len(items) != 2 {
+		t.Errorf("Expected 2 items, got %d (%v)", len(items), err)
+	}
+
+	// repair: a is missing from the primary storage, b from the secondary storage.
+	// The repair is one-way: a may have been deleted, it is not copied back to the primary storage
+	report, err := mirror.Repair("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Direction != RepairToSecondary || len(report.Copied) != 1 || len(report.Extra) != 1 || report.Extra[0] != "a" {
+		t.Errorf("Unexpected dry run report %+v", report)
+	}
+	if _, err = secondary.Get("b"); err != ErrNotFound {
+		t.Error("A dry run should not copy items")
+	}
+	report, err = mirror.Repair(RepairToSecondary, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0] != "b" || len(report.Updated) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if _, err = secondary.Get("b"); err != nil {
+		t.Errorf("Expected b in the secondary storage, got %v", err)
+	}
+	if _, err = primary.Get("a"); err != ErrNotFound {
+		t.Errorf("Expected a to stay missing from the primary storage, got %v", err)
+	}
+
+	// a stale copy is updated
+	if _, err = primary.Add("b", bytes.NewReader([]byte("bbbbbb"))); err != nil {
+		t.Fatal(err)
+	}
+	report, err = mirror.Repair(RepairToSecondary, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copied) != 0 || len(report.Updated) != 1 || report.Updated[0] != "b" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if item, err = secondary.Get("b"); err != nil || readItem(t, item) != "bbbbbb" {
+		t.Errorf("Expected the new b in the secondary storage, got %v", err)
+	}
+	// nothing is left to repair
+	if report, err = mirror.Repair(RepairToSecondary, false); err != nil || len(report.Copied)+len(report.Updated) != 0 {
+		t.Errorf("Unexpected report %+v (%v)", report, err)
+	}
+
+	// the primary storage is repaired from the secondary storage on request
+	report, err = mirror.Repair(RepairToPrimary, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0] != "a" || len(report.Updated) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	for _, s := range []Store{primary, secondary} {
+		for _, key := range []string{"a", "b"} {
+			if _, err = s.Get(key); err != nil {
+				t.Errorf("Expected %s in both storages, got %v", key, err)
+			}
+		}
+	}
+	if _, err = mirror.Repair("unknown", false); err == nil {
+		t.Error("Expected an error for an unknown direction")
+	}
+
+	if err = mirror.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mirror.Get("a"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err = mirror.Remove("a"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMirrorAsync(t *testing.T) {
+	primary, clean1 := newTempStore(t)
+	defer clean1()
+	fs2, clean2 := newTempStore(t)
+	defer clean2()
+	secondary := &flakyStore{Store: fs2, down: true}
+
+	mirror, err := NewMirror(primary, secondary, MirrorAsync, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		mirror.Replay(stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	// the write succeeds while the secondary storage is down
+	if _, err = mirror.Add("a", bytes.NewReader([]byte("aaaa"))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if mirror.Pending() != 1 {
+		t.Errorf("Expected 1 pending write, got %d", mirror.Pending())
+	}
+	// a repair leaves the item to the pending write
+	report, err := mirror.Repair(RepairToSecondary, true)
+	if err != nil || len(report.Pending) != 1 || len(report.Copied) != 0 {
+		t.Errorf("Unexpected report %+v (%v)", report, err)
+	}
+
+	// the write is retried
+	secondary.setDown(false)
+	for i := 0; i < 100 && mirror.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mirror.Pending() != 0 {
+		t.Fatal("The pending write has not been retried")
+	}
+	item, err := secondary.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readItem(t, item); s != "aaaa" {
+		t.Errorf("Expected aaaa, got %s", s)
+	}
+
+	if _, err = NewMirror(primary, secondary, "unknown", 0); err == nil {
+		t.Error("Expected an error for an unknown write policy")
+	}
+}
+
+// etagItem is an item with another ETag
+type etagItem struct {
+	Item
+	etag string
+}
+
+func (i etagItem) ETag() string {
+	return i.etag
+}
+
+func TestIsStale(t *testing.T) {
+	store, clean := newTempStore(t)
+	defer clean()
+	item, err := store.Add("a", bytes.NewReader([]byte("same size")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica := etagItem{Item: item, etag: `"other"`}
+
+	// the ETags are compared only if both backends compute them from the contents
+	if isStale(replica, item, true, false) {
+		t.Error("Expected a replica with a non comparable ETag to be up to date")
+	}
+	if !isStale(replica, item, true, true) {
+		t.Error("Expected a replica with another comparable ETag to be stale")
+	}
+	if isStale(etagItem{Item: item, etag: item.ETag()}, item, true, true) {
+		t.Error("Expected a replica with the same ETag to be up to date")
+	}
+	if store.ComparableETags() {
+		t.Error("Expected the ETags of a file system not to be comparable")
+	}
+}
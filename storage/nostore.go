@@ -6,6 +6,7 @@ package storage
 
 import (
 	"io"
+	"time"
 )
 
 // void storage, created to avoid breaking interfaces in case the storage is handled by the encryption tool.
@@ -31,6 +32,22 @@ func (i noItem) Contents() (io.ReadCloser, error) {
 	return nil, ErrNotFound
 }
 
+func (i noItem) ContentsRange(offset, length int64) (io.ReadCloser, error) {
+	return nil, ErrNotFound
+}
+
+func (i noItem) Size() int64 {
+	return -1
+}
+
+func (i noItem) ETag() string {
+	return ""
+}
+
+func (i noItem) LastModified() time.Time {
+	return time.Time{}
+}
+
 // noStorage functions
 
 func (s noStorage) Add(key string, r io.ReadSeeker) (Item, error) {
@@ -49,6 +66,10 @@ func (s noStorage) List() ([]Item, error) {
 	return nil, ErrNotFound
 }
 
+func (s noStorage) ComparableETags() bool {
+	return false
+}
+
 // NoStorage creates a new void storage
 //
 func NoStorage() Store {
@@ -0,0 +1,175 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// limitedReadCloser reads a limited part of a stream, then closes the stream
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// emptyReadCloser is an empty stream
+type emptyReadCloser struct{}
+
+func (emptyReadCloser) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (emptyReadCloser) Close() error {
+	return nil
+}
+
+// Lengths of the ranged reads of an ItemReader
+const (
+	minReadAhead = 64 << 10
+	maxReadAhead = 16 << 20
+)
+
+// ItemReader reads the contents of an item at random positions, via bounded ranged reads.
+// A range covers at least the requested bytes plus a read-ahead, so that the small reads of a zip reader
+// (local headers followed by entries) are served by the same range; its length doubles while the reads
+// are sequential, so that streaming the whole item only issues a few ranged reads.
+// It implements io.ReadSeeker, as required by http.ServeContent, and io.ReaderAt, as required by zip.NewReader.
+type ItemReader struct {
+	item   Item
+	offset int64 // position of the next Read
+
+	mu     sync.Mutex
+	stream io.ReadCloser // stream of the last ranged read, nil once consumed
+	pos    int64         // position of the stream
+	end    int64         // end of the last range
+	window int64         // length of the last range
+}
+
+// NewItemReader returns a reader of the contents of an item, which size must be known
+func NewItemReader(item Item) *ItemReader {
+	return &ItemReader{item: item}
+}
+
+// ReadAt reads len(p) bytes at a given offset
+func (r *ItemReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("storage: negative offset")
+	}
+	size := r.item.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) && off < size {
+		if err := r.seekStream(off, int64(len(p)-n), size); err != nil {
+			return n, err
+		}
+		chunk := p[n:]
+		if rest := r.end - r.pos; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		m, err := io.ReadFull(r.stream, chunk)
+		n += m
+		off += int64(m)
+		r.pos += int64(m)
+		if err != nil {
+			// the item is shorter than its size
+			r.closeStream()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+		if r.pos == r.end {
+			r.closeStream()
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// seekStream positions the stream at off, in the current range if off is slightly ahead of the stream,
+// or in a new range of at least length bytes
+func (r *ItemReader) seekStream(off, length, size int64) error {
+	if r.stream != nil && off >= r.pos && off < r.end && off-r.pos <= minReadAhead {
+		if _, err := io.CopyN(ioutil.Discard, r.stream, off-r.pos); err != nil {
+			r.closeStream()
+			return err
+		}
+		r.pos = off
+		return nil
+	}
+	r.closeStream()
+
+	// the range grows while the reads are sequential
+	if off == r.end && r.window > 0 {
+		r.window *= 2
+		if r.window > maxReadAhead {
+			r.window = maxReadAhead
+		}
+	} else {
+		r.window = minReadAhead
+	}
+	length += r.window
+	if off+length > size {
+		length = size - off
+	}
+	stream, err := r.item.ContentsRange(off, length)
+	if err != nil {
+		return err
+	}
+	r.stream, r.pos, r.end = stream, off, off+length
+	return nil
+}
+
+// closeStream closes the stream of the last ranged read
+func (r *ItemReader) closeStream() error {
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
+
+// Read reads from the current position
+func (r *ItemReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the position of the next Read
+func (r *ItemReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.item.Size()
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close closes the stream opened by the last read
+func (r *ItemReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeStream()
+}
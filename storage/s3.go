@@ -28,8 +28,11 @@ package storage
 import (
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -41,9 +44,12 @@ type s3store struct {
 }
 
 type s3item struct {
-	bucket string
-	key    string
-	store  *s3store
+	bucket       string
+	key          string
+	store        *s3store
+	size         int64
+	etag         string
+	lastModified time.Time
 }
 
 func (i s3item) Key() string {
@@ -55,12 +61,47 @@ func (i s3item) PublicURL() string {
 }
 
 func (i s3item) Contents() (io.ReadCloser, error) {
-	resp, err := i.store.client.GetObject(&s3.GetObjectInput{
+	return i.ContentsRange(0, -1)
+}
+
+func (i s3item) ContentsRange(offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(i.store.bucket),
 		Key:    aws.String(i.key),
-	})
+	}
+	if length >= 0 {
+		if length == 0 {
+			return emptyReadCloser{}, nil
+		}
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := i.store.client.GetObject(input)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return resp.Body, nil
+}
+
+func (i s3item) Size() int64 {
+	return i.size
+}
+
+func (i s3item) ETag() string {
+	return i.etag
+}
+
+func (i s3item) LastModified() time.Time {
+	return i.lastModified
+}
 
-	return resp.Body, err
+// s3Error maps a missing object to ErrNotFound
+func s3Error(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
 }
 
 func (s *s3store) Add(key string, r io.ReadSeeker) (Item, error) {
@@ -69,18 +110,28 @@ func (s *s3store) Add(key string, r io.ReadSeeker) (Item, error) {
 		Key:    aws.String(key),
 		Body:   r,
 	})
-
-	item := s3item{bucket: s.bucket, key: key, store: s}
-
-	return item, err
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(key)
 }
 
 func (s *s3store) Get(key string) (Item, error) {
-	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+	head, err := s.client.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
 	})
-	return s3item{bucket: s.bucket, key: key, store: s}, err
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return s3item{
+		bucket:       s.bucket,
+		key:          key,
+		store:        s,
+		size:         aws.Int64Value(head.ContentLength),
+		etag:         aws.StringValue(head.ETag),
+		lastModified: aws.TimeValue(head.LastModified),
+	}, nil
 }
 
 func (s *s3store) Remove(key string) error {
@@ -92,21 +143,36 @@ func (s *s3store) Remove(key string) error {
 	return err
 }
 
+// ComparableETags is true, as the ETags of S3 objects are digests of their contents,
+// for objects uploaded the same way
+func (s *s3store) ComparableETags() bool {
+	return true
+}
+
+// List returns the objects of the bucket; the objects are listed page by page, as S3 returns 1000 keys at most per call
 func (s *s3store) List() ([]Item, error) {
-	objects, err := s.client.ListObjects(&s3.ListObjectsInput{
+	var items []Item
+
+	err := s.client.ListObjectsPages(&s3.ListObjectsInput{
 		Bucket: aws.String(s.bucket),
+	}, func(objects *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range objects.Contents {
+			items = append(items, s3item{
+				bucket:       s.bucket,
+				key:          aws.StringValue(o.Key),
+				store:        s,
+				size:         aws.Int64Value(o.Size),
+				etag:         aws.StringValue(o.ETag),
+				lastModified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	var items []Item
-
-	for _, o := range objects.Contents {
-		items = append(items, s3item{bucket: s.bucket, key: *o.Key, store: s})
-	}
-
 	return items, nil
 }
 
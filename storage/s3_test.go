@@ -0,0 +1,47 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// listObjectsPage is a page of the ListObjects response of a S3 server
+const listObjectsPage = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Name>bucket</Name><IsTruncated>%t</IsTruncated>
+<Contents><Key>%s</Key><Size>4</Size><ETag>"etag"</ETag><LastModified>2022-01-01T00:00:00.000Z</LastModified></Contents>
+</ListBucketResult>`
+
+func TestS3ListPages(t *testing.T) {
+	// the server returns one object per page, the next page starts after the marker
+	pages := map[string]string{"": "a", "a": "b", "b": "c"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := pages[r.URL.Query().Get("marker")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, listObjectsPage, key != "c", key)
+	}))
+	defer server.Close()
+
+	store, err := S3(S3Config{Bucket: "bucket", Endpoint: server.URL, Region: "us-east-1",
+		ID: "id", Secret: "secret", DisableSSL: true, ForcePathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[0].Key() != "a" || items[2].Key() != "c" {
+		t.Errorf("Expected the objects of the 3 pages, got %d", len(items))
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAVConfig structure
+type WebDAVConfig struct {
+	// url of the collection in which the encrypted publications are stored
+	URL      string
+	Username string
+	Password string
+	// public url of the collection, if it differs from the WebDAV url
+	PublicURL string
+}
+
+type webdavStore struct {
+	base      *url.URL
+	username  string
+	password  string
+	publicURL string
+	client    *http.Client
+}
+
+type webdavItem struct {
+	key          string
+	store        *webdavStore
+	size         int64
+	etag         string
+	lastModified time.Time
+}
+
+// properties requested to the WebDAV server
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getetag/><D:getlastmodified/></D:prop></D:propfind>`
+
+// multistatus response to a PROPFIND request
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	ETag          string `xml:"DAV: getetag"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+}
+
+func (i webdavItem) Key() string {
+	return i.key
+}
+
+func (i webdavItem) PublicURL() string {
+	if i.store.publicURL != "" {
+		return strings.TrimSuffix(i.store.publicURL, "/") + "/" + url.PathEscape(i.key)
+	}
+	return i.store.url(i.key)
+}
+
+func (i webdavItem) Contents() (io.ReadCloser, error) {
+	return i.ContentsRange(0, -1)
+}
+
+func (i webdavItem) ContentsRange(offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return emptyReadCloser{}, nil
+	}
+	req, err := i.store.newRequest("GET", i.key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := i.store.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the server ignored the range
+		if offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		if length > 0 {
+			return limitedReadCloser{io.LimitReader(resp.Body, length), resp.Body}, nil
+		}
+		return resp.Body, nil
+	default:
+		resp.Body.Close()
+		return nil, statusError(resp)
+	}
+}
+
+func (i webdavItem) Size() int64 {
+	return i.size
+}
+
+func (i webdavItem) ETag() string {
+	return i.etag
+}
+
+func (i webdavItem) LastModified() time.Time {
+	return i.lastModified
+}
+
+// url returns the WebDAV url of a key, or of the collection if the key is empty
+func (s *webdavStore) url(key string) string {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, key)
+	if key == "" && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+func (s *webdavStore) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return req, nil
+}
+
+// statusError maps a missing resource to ErrNotFound
+func statusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return fmt.Errorf("webdav: unexpected status %s", resp.Status)
+}
+
+// propfind gets the properties of a resource (depth 0) or of the members of a collection (depth 1)
+func (s *webdavStore) propfind(key string, depth string) ([]davResponse, error) {
+	req, err := s.newRequest("PROPFIND", key, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(resp)
+	}
+	var ms davMultistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	return ms.Responses, nil
+}
+
+// newItem creates an item from the properties of a resource
+func (s *webdavStore) newItem(key string, r davResponse) (item *webdavItem, collection bool) {
+	item = &webdavItem{key: key, store: s, size: -1}
+	for _, ps := range r.Propstats {
+		// missing properties are reported with a 404 status
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		if ps.Prop.ResourceType.Collection != nil {
+			collection = true
+		}
+		if size, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+			item.size = size
+		}
+		if ps.Prop.ETag != "" {
+			item.etag = ps.Prop.ETag
+		}
+		if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+			item.lastModified = t
+		}
+	}
+	return
+}
+
+func (s *webdavStore) Add(key string, r io.ReadSeeker) (Item, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest("PUT", key, r)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, statusError(resp)
+	}
+	return s.Get(key)
+}
+
+func (s *webdavStore) Get(key string) (Item, error) {
+	responses, err := s.propfind(key, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, ErrNotFound
+	}
+	item, collection := s.newItem(key, responses[0])
+	if collection {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+func (s *webdavStore) Remove(key string) error {
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// ComparableETags is false, as the ETags of a WebDAV server are specific to this server
+func (s *webdavStore) ComparableETags() bool {
+	return false
+}
+
+func (s *webdavStore) List() ([]Item, error) {
+	responses, err := s.propfind("", "1")
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, r := range responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		// the collection itself and sub-collections are not items
+		item, collection := s.newItem(path.Base(href.Path), r)
+		if collection || strings.HasSuffix(href.Path, "/") {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// WebDAV inits a WebDAV storage
+func WebDAV(config WebDAVConfig) (Store, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("webdav: invalid url %s", config.URL)
+	}
+	return &webdavStore{
+		base:      base,
+		username:  config.Username,
+		password:  config.Password,
+		publicURL: config.PublicURL,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
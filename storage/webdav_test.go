@@ -0,0 +1,110 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// newWebDAVServer starts an in-memory WebDAV server, protected by basic auth, serving the /dav/ collection
+func newWebDAVServer(t *testing.T) *httptest.Server {
+	fs := webdav.NewMemFS()
+	if err := fs.Mkdir(nil, "/publications", 0755); err != nil {
+		t.Fatal(err)
+	}
+	handler := &webdav.Handler{Prefix: "/dav", FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "lcp" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func TestWebDAVStorage(t *testing.T) {
+	server := newWebDAVServer(t)
+	defer server.Close()
+
+	store, err := WebDAV(WebDAVConfig{URL: server.URL + "/dav/publications", Username: "lcp", Password: "secret",
+		PublicURL: "https://cdn.example.com/publications/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := store.Add("test", bytes.NewReader([]byte("test1234")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Key() != "test" {
+		t.Errorf("expected item key to be test, got %s", item.Key())
+	}
+	if item.PublicURL() != "https://cdn.example.com/publications/test" {
+		t.Errorf("unexpected public url %s", item.PublicURL())
+	}
+	if _, err = store.Add("other file", bytes.NewReader([]byte("other"))); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err = store.Get("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Size() != 8 || item.ETag() == "" || item.LastModified().IsZero() {
+		t.Errorf("unexpected properties: size %d, etag %s, modified %v", item.Size(), item.ETag(), item.LastModified())
+	}
+	contents, err := item.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(contents)
+	contents.Close()
+	if err != nil || string(b) != "test1234" {
+		t.Errorf("expected test1234, got %s (%v)", b, err)
+	}
+	contents, err = item.ContentsRange(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(contents)
+	contents.Close()
+	if err != nil || string(b) != "st1" {
+		t.Errorf("expected st1, got %s (%v)", b, err)
+	}
+
+	items, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]int64)
+	for _, it := range items {
+		keys[it.Key()] = it.Size()
+	}
+	if len(keys) != 2 || keys["test"] != 8 || keys["other file"] != 5 {
+		t.Errorf("unexpected list %v", keys)
+	}
+
+	if err = store.Remove("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get("test"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err = store.Remove("test"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// wrong credentials
+	store, _ = WebDAV(WebDAVConfig{URL: server.URL + "/dav/publications", Username: "lcp", Password: "wrong"})
+	if _, err = store.Get("other file"); err == nil || err == ErrNotFound {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+// content_keys_tool manages the protection of the content keys stored in the License Server database.
+// It uses the configuration file of the License Server.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/index"
+)
+
+func dbFromURI(uri string) (string, string) {
+	parts := strings.Split(uri, "://")
+	return parts[0], parts[1]
+}
+
+// exitWithError outputs an error message and exits.
+func exitWithError(context string, err error) {
+
+	fmt.Println(context, ":", err.Error())
+	os.Exit(1)
+}
+
+func main() {
+	var configFile = flag.String("config", "", "optional, path to the License Server configuration file; READIUM_LCPSERVER_CONFIG or config.yaml by default")
+	var protect = flag.Bool("protect", false, "wrap with the configured master key every content key still stored in clear")
+	var rotate = flag.Bool("rotate", false, "re-wrap with the current master key every content key protected by a previous master key")
+	var batch = flag.Int("batch", 100, "number of content keys updated per batch during a rotation")
+	flag.Parse()
+
+	if !*protect && !*rotate {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if *configFile == "" {
+		if *configFile = os.Getenv("READIUM_LCPSERVER_CONFIG"); *configFile == "" {
+			*configFile = "config.yaml"
+		}
+	}
+	config.ReadConfig(*configFile)
+
+	// use a sqlite db by default, as the License Server does
+	dbURI := config.Config.LcpServer.Database
+	if dbURI == "" {
+		dbURI = "sqlite3://file:lcp.sqlite?cache=shared&mode=rwc"
+	}
+	driver, cnxn := dbFromURI(dbURI)
+	db, err := sql.Open(driver, cnxn)
+	if err != nil {
+		exitWithError("Open the database", err)
+	}
+	defer db.Close()
+
+	idx, err := index.Open(db)
+	if err != nil {
+		exitWithError("Open the content index", err)
+	}
+
+	if *rotate {
+		// an interrupted rotation is resumed by running the tool again
+		count, err := index.RotateContentKeys(idx, *batch, func(done, total int) {
+			fmt.Printf("%d/%d content keys rotated\n", done, total)
+		})
+		if err != nil {
+			exitWithError("Rotate content keys", err)
+		}
+		fmt.Println(count, "content keys protected with the current master key")
+		return
+	}
+
+	count, err := index.ProtectContentKeys(idx)
+	if err != nil {
+		exitWithError("Protect content keys", err)
+	}
+	fmt.Println(count, "content keys protected with the master key")
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Readium Foundation. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file exposed on Github (readium) in the project repository.
+
+// storage_tool maintains the storage of encrypted publications of the License Server.
+// It uses the configuration file of the License Server.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/readium/readium-lcp-server/config"
+	"github.com/readium/readium-lcp-server/index"
+	"github.com/readium/readium-lcp-server/storage"
+)
+
+func dbFromURI(uri string) (string, string) {
+	parts := strings.Split(uri, "://")
+	return parts[0], parts[1]
+}
+
+// exitWithError outputs an error message and exits.
+func exitWithError(context string, err error) {
+
+	fmt.Println(context, ":", err.Error())
+	os.Exit(1)
+}
+
+// storageConfig returns the storage configuration of the License Server or of one of its tenants
+func storageConfig(tenantID string) (config.Storage, error) {
+	if tenantID == "" {
+		return config.Config.Storage, nil
+	}
+	for _, t := range config.Config.Tenants {
+		if t.ID == tenantID {
+			if !t.Storage.IsSet() {
+				return config.Storage{}, errors.New("tenant " + tenantID + " uses the main storage")
+			}
+			return t.Storage, nil
+		}
+	}
+	return config.Storage{}, errors.New("unknown tenant " + tenantID)
+}
+
+// auditIndexes returns the views of the content index which contents are expected in the storage of a tenant.
+// The main storage also holds the contents of the tenants without storage configuration.
+func auditIndexes(idx index.Index, tenantID string) ([]index.Index, error) {
+	ids := []string{tenantID}
+	if tenantID == "" {
+		for _, t := range config.Config.Tenants {
+			if !t.Storage.IsSet() {
+				ids = append(ids, t.ID)
+			}
+		}
+	}
+	var indexes []index.Index
+	for _, id := range ids {
+		t, err := idx.Tenant(id)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, t)
+	}
+	return indexes, nil
+}
+
+// audit checks the storage against the content index, and outputs the report
+func audit(store storage.Store, tenantID string) {
+	// use a sqlite db by default, as the License Server does
+	dbURI := config.Config.LcpServer.Database
+	if dbURI == "" {
+		dbURI = "sqlite3://file:lcp.sqlite?cache=shared&mode=rwc"
+	}
+	driver, cnxn := dbFromURI(dbURI)
+	db, err := sql.Open(driver, cnxn)
+	if err != nil {
+		exitWithError("Open the database", err)
+	}
+	defer db.Close()
+
+	idx, err := index.Open(db)
+	if err != nil {
+		exitWithError("Open the content index", err)
+	}
+	indexes, err := auditIndexes(idx, tenantID)
+	if err != nil {
+		exitWithError("Open the content index", err)
+	}
+	report, err := index.Audit(store, indexes...)
+	if err != nil {
+		exitWithError("Audit the storage", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if len(report.Issues) > 0 || len(report.Orphans) > 0 {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	var configFile = flag.String("config", "", "optional, path to the License Server configuration file; READIUM_LCPSERVER_CONFIG or config.yaml by default")
+	var tenant = flag.String("tenant", "", "optional, identifier of the tenant which storage is processed; the main storage by default")
+	var repair = flag.Bool("repair", false, "copy the items missing or stale in the secondary backend of a mirrored storage from the primary backend")
+	var toPrimary = flag.Bool("to-primary", false, "repair the primary backend from the secondary backend instead, e.g. after the loss of the primary backend")
+	var dryRun = flag.Bool("dry-run", false, "report the items to repair without copying them")
+	var auditStorage = flag.Bool("audit", false, "check the length and hash of the stored items against the content index, and report the orphaned items")
+	flag.Parse()
+
+	if !*repair && !*auditStorage {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if *configFile == "" {
+		if *configFile = os.Getenv("READIUM_LCPSERVER_CONFIG"); *configFile == "" {
+			*configFile = "config.yaml"
+		}
+	}
+	config.ReadConfig(*configFile)
+
+	conf, err := storageConfig(*tenant)
+	if err != nil {
+		exitWithError("Select the storage", err)
+	}
+	if !conf.IsSet() {
+		exitWithError("Select the storage", errors.New("no storage is configured"))
+	}
+	// asynchronous writes are not used by the tool
+	if conf.Mirror != nil {
+		conf.Mirror.WritePolicy = storage.MirrorSync
+	}
+	store, err := storage.New(conf)
+	if err != nil {
+		exitWithError("Open the storage", err)
+	}
+
+	if *auditStorage {
+		audit(store, *tenant)
+		return
+	}
+
+	mirror, ok := store.(*storage.Mirror)
+	if !ok {
+		exitWithError("Repair the storage", errors.New("the storage is not mirrored"))
+	}
+	direction := storage.RepairToSecondary
+	if *toPrimary {
+		direction = storage.RepairToPrimary
+	}
+	report, err := mirror.Repair(direction, *dryRun)
+	if err != nil {
+		exitWithError("Repair the storage", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}